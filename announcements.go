@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"golang.org/x/net/html"
+)
+
+const (
+	embedColor                = 0xf5a623
+	embedTitleLimit           = 256
+	embedAuthorLimit          = 256
+	embedFooterLimit          = 2048
+	mediaFileNameLimit        = 100
+	announcementSummaryLimit  = 350
+	articleDescriptionStorage = 4096
+)
+
+// articleEmbed renders an article as a Discord embed for announcements.
+func articleEmbed(art *Article, feedTitle string) *discordgo.MessageEmbed {
+	title := art.Title
+	if title == "" {
+		title = art.Link
+	}
+
+	embed := &discordgo.MessageEmbed{
+		URL:         art.Link,
+		Title:       truncate(title, embedTitleLimit),
+		Description: truncate(art.Description, announcementSummaryLimit),
+		Color:       embedColor,
+	}
+
+	// An item that wasn't dated by its feed would otherwise look like it
+	// was published whenever goose happened to find it.
+	if !art.Published.IsZero() && art.DateSource != DateSourceFirstSeen {
+		embed.Timestamp = art.Published.UTC().Format(time.RFC3339)
+	}
+
+	if art.Author != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: truncate(art.Author, embedAuthorLimit)}
+	}
+
+	if art.ImageURL != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: art.ImageURL}
+	}
+
+	if feedTitle != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: truncate(feedTitle, embedFooterLimit)}
+	}
+
+	embed.Fields = mediaFields(art)
+
+	return embed
+}
+
+// mediaFields describe the episode, length and file of an article about
+// audio or video, such as a podcast episode.
+func mediaFields(art *Article) []*discordgo.MessageEmbedField {
+	var fields []*discordgo.MessageEmbedField
+
+	if label := episodeLabel(art); label != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Episode", Value: truncate(label, embedFieldValueLimit), Inline: true})
+	}
+
+	if art.Duration > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Length", Value: formatDuration(art.Duration), Inline: true})
+	}
+
+	if art.MediaURL != "" {
+		name := "Attachment"
+		switch mediaKind(art.MediaType) {
+		case "audio":
+			name = "🎧 Listen"
+		case "video":
+			name = "🎬 Watch"
+		}
+
+		file := "Download"
+		if u, err := url.Parse(art.MediaURL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			file = path.Base(u.Path)
+		}
+
+		// Links that don't fit are left out rather than cut short.
+		value := fmt.Sprintf("[%s](%s)", escapeLinkText(truncate(file, mediaFileNameLimit)), art.MediaURL)
+		if utf8.RuneCountInString(value) <= embedFieldValueLimit {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: name, Value: value, Inline: true})
+		}
+	}
+
+	return fields
+}
+
+// escapeLinkText keeps characters that end a markdown link's text from
+// breaking it.
+func escapeLinkText(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
+
+// newArticle extracts the fields goose keeps about a feed item that was
+// first seen at firstSeen.
+func newArticle(feedID int64, item *gofeed.Item, firstSeen time.Time) Article {
+	art := Article{
+		FeedID:    feedID,
+		GUID:      itemGUID(item),
+		Title:     strings.TrimSpace(item.Title),
+		Link:      item.Link,
+		FirstSeen: firstSeen.UTC(),
+	}
+
+	art.Published, art.DateSource = itemDate(item, firstSeen)
+
+	switch {
+	case item.Author != nil && item.Author.Name != "":
+		art.Author = item.Author.Name
+	case len(item.Authors) > 0 && item.Authors[0] != nil:
+		art.Author = item.Authors[0].Name
+	}
+
+	description := item.Description
+	if description == "" {
+		description = item.Content
+	}
+	art.Description = truncate(stripHTML(description), articleDescriptionStorage)
+
+	art.ImageURL = itemImage(item)
+	art.Categories = item.Categories
+
+	if media := itemMedia(item); media != nil {
+		art.MediaURL = media.URL
+		art.MediaType = media.Type
+	}
+
+	if itunes := item.ITunesExt; itunes != nil {
+		art.Episode = strings.TrimSpace(itunes.Episode)
+		art.Season = strings.TrimSpace(itunes.Season)
+		art.Duration = parseDuration(itunes.Duration)
+	}
+
+	if item.UpdatedParsed != nil {
+		art.Updated = item.UpdatedParsed.UTC()
+	}
+	art.ContentHash = itemHash(item)
+
+	return art
+}
+
+// itemHash fingerprints what an item says, so that goose can tell when a
+// feed changes an item it already has. Only the item's full text goes
+// into it, not what's kept of it, so edits past the stored description
+// still count. The link is normalized the way itemGUID does it, so a feed
+// that rotates tracking parameters doesn't change the item.
+func itemHash(item *gofeed.Item) string {
+	h := sha256.New()
+
+	fields := []string{item.Title, normalizeURL(item.Link), item.Description, item.Content, itemImage(item)}
+	if media := itemMedia(item); media != nil {
+		fields = append(fields, media.URL)
+	}
+	if item.ITunesExt != nil {
+		fields = append(fields, item.ITunesExt.Episode, item.ITunesExt.Season, item.ITunesExt.Duration)
+	}
+	if item.Author != nil {
+		fields = append(fields, item.Author.Name)
+	}
+	for _, author := range item.Authors {
+		if author != nil {
+			fields = append(fields, author.Name)
+		}
+	}
+	fields = append(fields, item.Categories...)
+
+	for _, f := range fields {
+		h.Write([]byte(strings.TrimSpace(f)))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// itemGUID identifies an item within its feed by its GUID, or else by its
+// normalized link, or else by its title. Items with none of those are
+// told apart by their content, since they'd otherwise all share one
+// identity and only the first would ever be announced.
+func itemGUID(item *gofeed.Item) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return guid
+	}
+	if item.Link != "" {
+		return normalizeURL(item.Link)
+	}
+	if title := strings.TrimSpace(item.Title); title != "" {
+		return "title:" + title
+	}
+	return "hash:" + itemHash(item)
+}
+
+// trackingParams are query parameters that only tell a website where a
+// visitor came from.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+// normalizeURL puts a link into a canonical form so that the same page
+// is recognized when a feed changes how it links to it: the scheme and
+// host are lowercased, default ports are dropped, tracking parameters
+// such as utm_source are removed and the remaining parameters are sorted.
+func normalizeURL(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(link)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	switch {
+	case u.Scheme == "http" && u.Port() == "80", u.Scheme == "https" && u.Port() == "443":
+		u.Host = strings.TrimSuffix(u.Host, ":"+u.Port())
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	for param := range query {
+		if strings.HasPrefix(strings.ToLower(param), "utm_") || trackingParams[strings.ToLower(param)] {
+			query.Del(param)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// itemDate picks the date to order an item by: when it was published,
+// or else when it was last updated, or else when goose first saw it.
+func itemDate(item *gofeed.Item, firstSeen time.Time) (time.Time, DateSource) {
+	switch {
+	case item.PublishedParsed != nil:
+		return item.PublishedParsed.UTC(), DateSourcePublished
+	case item.UpdatedParsed != nil:
+		return item.UpdatedParsed.UTC(), DateSourceUpdated
+	default:
+		return firstSeen.UTC(), DateSourceFirstSeen
+	}
+}
+
+// itemImage picks the best image to use as an item's thumbnail: its own
+// image, such as a podcast episode's artwork, or else its Media RSS
+// thumbnail, or else an image attached to it.
+func itemImage(item *gofeed.Item) string {
+	if item.Image != nil && item.Image.URL != "" {
+		return item.Image.URL
+	}
+
+	if thumbnail := mediaThumbnail(item.Extensions["media"]); thumbnail != "" {
+		return thumbnail
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure != nil && strings.HasPrefix(enclosure.Type, "image/") {
+			return enclosure.URL
+		}
+	}
+
+	return ""
+}
+
+// mediaThumbnail finds the URL of a <media:thumbnail>, which may also be
+// nested in <media:group> or <media:content> as it is in YouTube's feeds.
+func mediaThumbnail(media map[string][]ext.Extension) string {
+	for _, thumbnail := range media["thumbnail"] {
+		if u := strings.TrimSpace(thumbnail.Attrs["url"]); u != "" {
+			return u
+		}
+	}
+
+	for _, key := range []string{"group", "content"} {
+		for _, e := range media[key] {
+			if u := mediaThumbnail(e.Children); u != "" {
+				return u
+			}
+		}
+	}
+
+	return ""
+}
+
+// itemMedia picks the audio or video file attached to an item, or any
+// other file that isn't an image.
+func itemMedia(item *gofeed.Item) *gofeed.Enclosure {
+	var other *gofeed.Enclosure
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure == nil || strings.TrimSpace(enclosure.URL) == "" {
+			continue
+		}
+
+		switch mediaKind(enclosure.Type) {
+		case "audio", "video":
+			return enclosure
+		case "image":
+		default:
+			if other == nil {
+				other = enclosure
+			}
+		}
+	}
+
+	return other
+}
+
+// mediaKind is the top-level type of a media type, e.g. "audio" for
+// "audio/mpeg".
+func mediaKind(mediaType string) string {
+	kind, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(mediaType)), "/")
+	return kind
+}
+
+// durationMax is the longest <itunes:duration> taken at its word. Nothing
+// published runs for 1000 hours, so longer ones are typos or garbage.
+const durationMax = 1000 * time.Hour
+
+// parseDuration reads an <itunes:duration>, which is either a number of
+// seconds or hours, minutes and seconds separated by colons. A fraction
+// of a second is dropped.
+func parseDuration(s string) time.Duration {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	last, fraction, ok := strings.Cut(parts[len(parts)-1], ".")
+	if ok {
+		if _, err := strconv.ParseUint(fraction, 10, 64); err != nil {
+			return 0
+		}
+		parts[len(parts)-1] = last
+	}
+
+	maxSecs := uint64(durationMax / time.Second)
+
+	var secs uint64
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil || n >= maxSecs {
+			return 0
+		}
+
+		secs = secs*60 + n
+		if secs >= maxSecs {
+			return 0
+		}
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// formatDuration writes d the way media players do, e.g. "1:02:03" or
+// "45:10".
+func formatDuration(d time.Duration) string {
+	secs := int64(d / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// episodeLabel describes which episode an article is, e.g. "Season 2,
+// episode 5".
+func episodeLabel(art *Article) string {
+	switch {
+	case art.Season != "" && art.Episode != "":
+		return fmt.Sprintf("Season %s, episode %s", art.Season, art.Episode)
+	case art.Episode != "":
+		return "Episode " + art.Episode
+	case art.Season != "":
+		return "Season " + art.Season
+	default:
+		return ""
+	}
+}
+
+// stripHTML returns the text content of an HTML fragment with runs of
+// whitespace collapsed.
+func stripHTML(s string) string {
+	var sb strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+		case html.TextToken:
+			if skip == 0 {
+				sb.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case "br", "p", "div", "li":
+				sb.WriteByte(' ')
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				if skip > 0 {
+					skip--
+				}
+			case "p", "div", "li":
+				sb.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// truncate shortens s to at most limit runes, marking the cut with an
+// ellipsis.
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
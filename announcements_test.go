@@ -0,0 +1,400 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "plain text", want: "plain text"},
+		{input: "<p>Hello <b>world</b></p><p>Again</p>", want: "Hello world Again"},
+		{input: "line one<br/>line two", want: "line one line two"},
+		{input: "Fish &amp; chips", want: "Fish & chips"},
+		{input: "<script>alert(1)</script>visible<style>p{}</style>", want: "visible"},
+		{input: "  lots\n\n of   space  ", want: "lots of space"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := stripHTML(tt.input)
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		input string
+		limit int
+		want  string
+	}{
+		{input: "short", limit: 10, want: "short"},
+		{input: "exactly10!", limit: 10, want: "exactly10!"},
+		{input: "this is far too long", limit: 10, want: "this is f…"},
+		{input: "🪿🪿🪿🪿", limit: 3, want: "🪿🪿…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := truncate(tt.input, tt.limit)
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewArticle(t *testing.T) {
+	published := time.Date(2023, 8, 1, 12, 0, 0, 0, time.FixedZone("EDT", -4*60*60))
+
+	item := &gofeed.Item{
+		Title:           " Release v1.2.3 ",
+		Link:            "https://example.com/releases/v1.2.3",
+		Description:     "<p>Lots of <em>fixes</em>.</p>",
+		PublishedParsed: &published,
+		Authors:         []*gofeed.Person{{Name: "Mother Goose"}},
+		Enclosures: []*gofeed.Enclosure{
+			{URL: "https://example.com/audio.mp3", Type: "audio/mpeg"},
+			{URL: "https://example.com/cover.png", Type: "image/png"},
+		},
+	}
+
+	want := Article{
+		FeedID:      7,
+		GUID:        "https://example.com/releases/v1.2.3",
+		Title:       "Release v1.2.3",
+		Link:        "https://example.com/releases/v1.2.3",
+		Published:   published.UTC(),
+		Author:      "Mother Goose",
+		Description: "Lots of fixes.",
+		ImageURL:    "https://example.com/cover.png",
+		MediaURL:    "https://example.com/audio.mp3",
+		MediaType:   "audio/mpeg",
+		DateSource:  DateSourcePublished,
+		FirstSeen:   published.UTC(),
+		ContentHash: itemHash(item),
+	}
+
+	got := newArticle(7, item, published)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want [%+v], got [%+v]", want, got)
+	}
+}
+
+func TestNewArticleFromFixtures(t *testing.T) {
+	// media is what's compared of each article.
+	type media struct {
+		Title     string
+		Published time.Time
+		Author    string
+		ImageURL  string
+		MediaURL  string
+		MediaType string
+		Episode   string
+		Season    string
+		Duration  time.Duration
+	}
+
+	tests := []struct {
+		fixture string
+		want    media
+	}{
+		{
+			fixture: "testdata/podcast.xml",
+			want: media{
+				Title:     "Migration season",
+				Published: time.Date(2023, 8, 10, 5, 0, 0, 0, time.UTC),
+				ImageURL:  "https://podcast.example.com/episodes/42.jpg",
+				MediaURL:  "https://cdn.example.com/honk/042-migration-season.mp3",
+				MediaType: "audio/mpeg",
+				Episode:   "42",
+				Season:    "3",
+				Duration:  time.Hour + 2*time.Minute + 3*time.Second,
+			},
+		},
+		{
+			fixture: "testdata/youtube.xml",
+			want: media{
+				Title:     "Goslings take their first swim",
+				Published: time.Date(2023, 8, 9, 15, 30, 0, 0, time.UTC),
+				Author:    "Goose Cam",
+				ImageURL:  "https://i1.ytimg.com/vi/hOnKhOnK123/hqdefault.jpg",
+			},
+		},
+		{
+			fixture: "testdata/feed.json",
+			want: media{
+				Title:     "Seven ways to say honk",
+				Published: time.Date(2023, 8, 8, 10, 0, 0, 0, time.UTC),
+				ImageURL:  "https://gander.example.com/episodes/7.png",
+				MediaURL:  "https://gander.example.com/media/episode-7.mp4",
+				MediaType: "video/mp4",
+			},
+		},
+		{
+			fixture: "testdata/rdf.xml",
+			want: media{
+				Title:     "Council approves a new dock",
+				Published: time.Date(2023, 8, 7, 8, 15, 0, 0, time.UTC),
+				Author:    "Pond Gazette staff",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, err := os.Open(tt.fixture)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer f.Close()
+
+			feed, err := gofeed.NewParser().Parse(f)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			art := newArticle(1, feed.Items[0], time.Now())
+			got := media{
+				Title:     art.Title,
+				Published: art.Published,
+				Author:    art.Author,
+				ImageURL:  art.ImageURL,
+				MediaURL:  art.MediaURL,
+				MediaType: art.MediaType,
+				Episode:   art.Episode,
+				Season:    art.Season,
+				Duration:  art.Duration,
+			}
+
+			if got != tt.want {
+				t.Errorf("want [%+v], got [%+v]", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+	}{
+		{input: "95", want: 95 * time.Second},
+		{input: "45:10", want: 45*time.Minute + 10*time.Second},
+		{input: "01:02:03", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{input: " 3723.5 ", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{input: "", want: 0},
+		{input: "an hour", want: 0},
+		{input: "1:2:3:4", want: 0},
+		{input: "-5", want: 0},
+		{input: "+5", want: 0},
+		{input: "1.", want: 0},
+		{input: "NaN", want: 0},
+		{input: "Inf", want: 0},
+		{input: "1e12", want: 0},
+		{input: "999:59:59", want: durationMax - time.Second},
+		{input: "1000:00:00", want: 0},
+		{input: "18446744073709551615", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := parseDuration(tt.input)
+			if got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMediaFields(t *testing.T) {
+	tests := []struct {
+		name string
+		art  Article
+		want []string
+	}{
+		{
+			name: "article",
+			art:  Article{Title: "Goose spotted", Link: "https://example.com/geese/1"},
+		},
+		{
+			name: "podcast episode",
+			art: Article{
+				MediaURL:  "https://cdn.example.com/honk/042-migration-season.mp3",
+				MediaType: "audio/mpeg",
+				Episode:   "42",
+				Season:    "3",
+				Duration:  time.Hour + 2*time.Minute + 3*time.Second,
+			},
+			want: []string{
+				"Episode: Season 3, episode 42",
+				"Length: 1:02:03",
+				"🎧 Listen: [042-migration-season.mp3](https://cdn.example.com/honk/042-migration-season.mp3)",
+			},
+		},
+		{
+			name: "video",
+			art:  Article{MediaURL: "https://example.com/media/", MediaType: "video/mp4", Duration: 45*time.Minute + 10*time.Second},
+			want: []string{
+				"Length: 45:10",
+				"🎬 Watch: [media](https://example.com/media/)",
+			},
+		},
+		{
+			name: "attachment",
+			art:  Article{MediaURL: "https://example.com/minutes[final].pdf", MediaType: "application/pdf", Episode: "7"},
+			want: []string{
+				"Episode: Episode 7",
+				`Attachment: [minutes\[final\].pdf](https://example.com/minutes[final].pdf)`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range mediaFields(&tt.art) {
+				got = append(got, f.Name+": "+f.Value)
+			}
+
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestItemHash(t *testing.T) {
+	item := func() *gofeed.Item {
+		return &gofeed.Item{
+			Title:       "Goose spotted",
+			Link:        "https://example.com/geese/1",
+			Description: "Honk.",
+			Content:     "<p>Honk honk.</p>",
+			Categories:  []string{"birds"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*gofeed.Item)
+		same   bool
+	}{
+		{name: "unchanged", change: func(*gofeed.Item) {}, same: true},
+		{name: "surrounding whitespace", change: func(i *gofeed.Item) { i.Title = " Goose spotted\n" }, same: true},
+		{name: "new date", change: func(i *gofeed.Item) { i.Updated = "2023-08-02T12:00:00Z" }, same: true},
+		{name: "tracking parameters", change: func(i *gofeed.Item) { i.Link += "?utm_source=rss&fbclid=honk" }, same: true},
+		{name: "title", change: func(i *gofeed.Item) { i.Title = "Geese spotted" }},
+		{name: "content", change: func(i *gofeed.Item) { i.Content = "<p>Honk honk honk.</p>" }},
+		{name: "categories", change: func(i *gofeed.Item) { i.Categories = append(i.Categories, "ponds") }},
+		{name: "author", change: func(i *gofeed.Item) { i.Authors = []*gofeed.Person{{Name: "Mother Goose"}} }},
+		{name: "fields run together", change: func(i *gofeed.Item) { i.Title, i.Link = "Goose spottedhttps://example.com/geese/1", "" }},
+	}
+
+	want := itemHash(item())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := item()
+			tt.change(changed)
+
+			got := itemHash(changed)
+			if (got == want) != tt.same {
+				t.Errorf("want same=%v, got hash %q for %q", tt.same, got, want)
+			}
+		})
+	}
+}
+
+func TestItemDate(t *testing.T) {
+	published := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC)
+	firstSeen := time.Date(2023, 8, 3, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		item       *gofeed.Item
+		wantDate   time.Time
+		wantSource DateSource
+	}{
+		{"published", &gofeed.Item{PublishedParsed: &published, UpdatedParsed: &updated}, published, DateSourcePublished},
+		{"updated", &gofeed.Item{UpdatedParsed: &updated}, updated, DateSourceUpdated},
+		{"undated", &gofeed.Item{}, firstSeen, DateSourceFirstSeen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, source := itemDate(tt.item, firstSeen)
+			if !date.Equal(tt.wantDate) || source != tt.wantSource {
+				t.Errorf("want %v from %q, got %v from %q", tt.wantDate, tt.wantSource, date, source)
+			}
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://example.com/post", "https://example.com/post"},
+		{"HTTPS://Example.COM/Post", "https://example.com/Post"},
+		{"https://example.com:443/post", "https://example.com/post"},
+		{"http://example.com:8080/post", "http://example.com:8080/post"},
+		{"https://example.com", "https://example.com/"},
+		{"https://example.com/post?utm_source=rss&utm_medium=feed&id=7", "https://example.com/post?id=7"},
+		{"https://example.com/post?b=2&a=1&fbclid=abc", "https://example.com/post?a=1&b=2"},
+		{"https://example.com/post?UTM_Campaign=x", "https://example.com/post"},
+		{"https://example.com/post#comments", "https://example.com/post#comments"},
+		{"not a link", "not a link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			got := normalizeURL(tt.link)
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestItemGUID(t *testing.T) {
+	tests := []struct {
+		name string
+		item *gofeed.Item
+		want string
+	}{
+		{"guid", &gofeed.Item{GUID: " tag:example.com,2023:1 ", Link: "https://example.com/1"}, "tag:example.com,2023:1"},
+		{"link", &gofeed.Item{Link: "https://Example.com/1?utm_source=rss"}, "https://example.com/1"},
+		{"title", &gofeed.Item{Title: "Honk"}, "title:Honk"},
+		{"content", &gofeed.Item{Description: "Honk."}, "hash:" + itemHash(&gofeed.Item{Description: "Honk."})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := itemGUID(tt.item)
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// Items with nothing but their content to go by still have identities
+	// of their own.
+	first := itemGUID(&gofeed.Item{Description: "Honk."})
+	second := itemGUID(&gofeed.Item{Description: "Honk honk."})
+	if first == second {
+		t.Errorf("want untitled items with different content told apart, got %q for both", first)
+	}
+}
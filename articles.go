@@ -3,48 +3,258 @@ package main
 import (
 	"database/sql"
 	"errors"
-	"net/url"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// DateSource says where an article's publication date came from.
+type DateSource string
+
+const (
+	DateSourcePublished DateSource = "published"
+	DateSourceUpdated   DateSource = "updated"
+
+	// DateSourceFirstSeen is for items without a date of their own,
+	// which are dated by when goose first saw them.
+	DateSourceFirstSeen DateSource = "first_seen"
+)
+
+// legacyGUIDPrefix marks the GUIDs given to articles that were stored
+// before goose kept track of GUIDs. The rest of the GUID is the article's
+// link.
+const legacyGUIDPrefix = "legacy:"
+
 type Article struct {
-	ID        int64
-	FeedID    int64
-	Title     string
-	Link      string
-	Published time.Time
+	ID     int64
+	FeedID int64
+
+	// GUID identifies the article within its feed. Articles without a
+	// GUID of their own are identified by their normalized link.
+	GUID string
+
+	Title       string
+	Link        string
+	Published   time.Time
+	Author      string
+	Description string
+	ImageURL    string
+	Categories  []string
+	DateSource  DateSource
+	FirstSeen   time.Time
+
+	// ContentHash changes whenever the feed changes what the item says,
+	// and Updated is when the feed last said it changed, if it does.
+	ContentHash string
+	Updated     time.Time
+
+	// MediaURL is the audio or video file an item such as a podcast
+	// episode is about. Episode, Season and Duration describe it when
+	// the feed says.
+	MediaURL  string
+	MediaType string
+	Episode   string
+	Season    string
+	Duration  time.Duration
 }
 
 type Articles struct {
 	db *sql.DB
 }
 
-func (a *Articles) Create(feedID int64, title string, link *url.URL, published time.Time) (*Article, error) {
-	stmt := `INSERT INTO articles (feed_id, title, link, pub_date) VALUES ($1, $2, $3, $4) RETURNING id, feed_id, title, link, pub_date`
-	args := []any{feedID, title, link.String(), published}
+const articleColumns = `id, feed_id, guid, title, link, pub_date, author, description, image_url, categories, date_source, first_seen, content_hash, updated_at, media_url, media_type, episode, season, duration_secs`
+
+func scanArticle(row rowScanner, art *Article) error {
+	var updated sql.NullTime
+	var durationSecs int64
+
+	err := row.Scan(&art.ID, &art.FeedID, &art.GUID, &art.Title, &art.Link, &art.Published, &art.Author, &art.Description, &art.ImageURL, pq.Array(&art.Categories), &art.DateSource, &art.FirstSeen, &art.ContentHash, &updated,
+		&art.MediaURL, &art.MediaType, &art.Episode, &art.Season, &durationSecs)
+	if err != nil {
+		return err
+	}
+
+	art.Updated = updated.Time
+	art.Duration = time.Duration(durationSecs) * time.Second
+
+	return nil
+}
+
+// Create adds a new article and queues a delivery of it to every
+// subscription on its feed, however its date compares to what they
+// already announced. It returns ErrAlreadyExists if the feed already has
+// an article with the same GUID.
+func (a *Articles) Create(article Article) (*Article, error) {
+	guid := article.GUID
+	if guid == "" {
+		guid = normalizeURL(article.Link)
+	}
+
+	categories := article.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dateSource := article.DateSource
+	if dateSource == "" {
+		dateSource = DateSourcePublished
+	}
+
+	firstSeen := article.FirstSeen
+	if firstSeen.IsZero() {
+		firstSeen = time.Now()
+	}
+
+	// An article from before GUIDs were kept takes on its GUID the first
+	// time it's seen again instead of being added a second time.
+	stmt := `UPDATE articles SET guid = $3 WHERE feed_id = $1 AND guid = $2`
+	args := []any{article.FeedID, legacyGUIDPrefix + article.Link, guid}
+
+	res, err := tx.Exec(stmt, args...)
+	if isUniqueViolation(err) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if n > 0 {
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrAlreadyExists
+	}
+
+	updated := sql.NullTime{Time: article.Updated.UTC(), Valid: !article.Updated.IsZero()}
+
+	stmt = `INSERT INTO articles (feed_id, guid, title, link, pub_date, author, description, image_url, categories, date_source, first_seen, content_hash, updated_at, media_url, media_type, episode, season, duration_secs)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) RETURNING ` + articleColumns
+	args = []any{article.FeedID, guid, article.Title, article.Link, article.Published.UTC(), article.Author, article.Description, article.ImageURL, pq.Array(categories), dateSource, firstSeen.UTC(), article.ContentHash, updated,
+		article.MediaURL, article.MediaType, article.Episode, article.Season, int64(article.Duration / time.Second)}
 
 	var art Article
-	var pqerr *pq.Error
 
-	err := a.db.QueryRow(stmt, args...).Scan(&art.ID, &art.FeedID, &art.Title, &art.Link, &art.Published)
-	if errors.As(err, &pqerr) && pqerr.Code == uniqueViolation {
+	err = scanArticle(tx.QueryRow(stmt, args...), &art)
+	if isUniqueViolation(err) {
 		return nil, ErrAlreadyExists
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	stmt = `INSERT INTO deliveries (subscription_id, article_id, not_before)
+		SELECT id, $2, $3 FROM subscriptions WHERE feed_id = $1
+		ON CONFLICT (subscription_id, article_id) DO NOTHING`
+	args = []any{art.FeedID, art.ID, time.Now().UTC()}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
 	return &art, nil
 }
 
+// Update stores what a feed now says about an article it already has,
+// keeping its publication date. It reports whether the article's content
+// changed, in which case the announcements of it are queued again for
+// every collection that follows updates. Articles stored before their
+// content was hashed take on a hash without counting as changed. It
+// returns ErrNotFound if the feed has no article with the same GUID.
+func (a *Articles) Update(article Article) (bool, error) {
+	guid := article.GUID
+	if guid == "" {
+		guid = normalizeURL(article.Link)
+	}
+
+	categories := article.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, content_hash FROM articles WHERE feed_id = $1 AND guid = $2`
+	args := []any{article.FeedID, guid}
+
+	var id int64
+	var hash string
+
+	err = tx.QueryRow(stmt, args...).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if hash == article.ContentHash {
+		return false, nil
+	}
+
+	updated := sql.NullTime{Time: article.Updated.UTC(), Valid: !article.Updated.IsZero()}
+
+	stmt = `UPDATE articles SET title = $2, link = $3, author = $4, description = $5, image_url = $6, categories = $7, content_hash = $8, updated_at = $9,
+		media_url = $10, media_type = $11, episode = $12, season = $13, duration_secs = $14 WHERE id = $1`
+	args = []any{id, article.Title, article.Link, article.Author, article.Description, article.ImageURL, pq.Array(categories), article.ContentHash, updated,
+		article.MediaURL, article.MediaType, article.Episode, article.Season, int64(article.Duration / time.Second)}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return false, err
+	}
+
+	changed := hash != ""
+	if changed {
+		stmt = `UPDATE deliveries SET status = $2, attempts = 0, not_before = $3, last_error = '', is_update = TRUE
+			WHERE article_id = $1 AND status = $4 AND subscription_id IN (
+				SELECT subscriptions.id FROM subscriptions
+				INNER JOIN collections ON subscriptions.collection_id = collections.id
+				WHERE collections.update_mode <> $5
+			)`
+		args = []any{id, deliveryPending, time.Now().UTC(), deliverySent, UpdateModeOff}
+
+		_, err = tx.Exec(stmt, args...)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
 func (a *Articles) Latest(feedID int64) (*Article, error) {
-	stmt := `SELECT id, feed_id, title, link, pub_date FROM articles WHERE feed_id = $1 ORDER BY pub_date DESC`
+	stmt := `SELECT ` + articleColumns + ` FROM articles WHERE feed_id = $1 ORDER BY pub_date DESC, id DESC`
 	args := []any{feedID}
 
 	var art Article
-	err := a.db.QueryRow(stmt, args...).Scan(&art.ID, &art.FeedID, &art.Title, &art.Link, &art.Published)
+	err := scanArticle(a.db.QueryRow(stmt, args...), &art)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -54,3 +264,28 @@ func (a *Articles) Latest(feedID int64) (*Article, error) {
 
 	return &art, nil
 }
+
+// Hashes returns the content hash of every article on a feed, keyed by
+// GUID, so a crawl can tell which items changed without asking about
+// each one.
+func (a *Articles) Hashes(feedID int64) (map[string]string, error) {
+	stmt := `SELECT guid, content_hash FROM articles WHERE feed_id = $1`
+	args := []any{feedID}
+
+	rows, err := a.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var guid, hash string
+		if err := rows.Scan(&guid, &hash); err != nil {
+			return nil, err
+		}
+		hashes[guid] = hash
+	}
+
+	return hashes, rows.Err()
+}
@@ -19,7 +19,7 @@ type AutoCompletions struct {
 	ac     map[string]*haystack
 	expiry time.Time
 
-	subscriptions *Subscriptions
+	collections CollectionStore
 }
 
 func (ac *AutoCompletions) CollectionNames(serverID, input string) ([]string, error) {
@@ -35,7 +35,7 @@ func (ac *AutoCompletions) CollectionNames(serverID, input string) ([]string, er
 
 	lookup, ok := ac.ac[serverID]
 	if !ok {
-		collections, err := ac.subscriptions.GetCollectionNames(serverID)
+		collections, err := ac.collections.Names(serverID)
 		if err != nil {
 			return nil, err
 		}
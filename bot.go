@@ -1,13 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,20 +18,38 @@ import (
 	"golang.org/x/time/rate"
 )
 
-const (
-	defaultCache = int64(21600)
-)
-
 type Bot struct {
-	articles        *Articles
-	feeds           *Feeds
-	subscriptions   *Subscriptions
+	articles        ArticleStore
+	feeds           FeedStore
+	collections     CollectionStore
+	subscriptions   SubscriptionStore
+	filters         FilterStore
+	deliveries      DeliveryStore
 	autocompletions *AutoCompletions
 
 	rateLimiter *rate.Limiter
-	session     *discordgo.Session
+	shards      *Shards
+
+	httpClient  *http.Client
+	cachePolicy *CachePolicy
+	crawler     *Crawler
+
+	disableAfterFailures int
+
+	// lookback is how old an item goose hasn't seen before can be and
+	// still be announced. Zero announces every new item however old.
+	lookback time.Duration
+
+	// isLeader reports whether this process still holds the leader
+	// lease, which is checked again before each crawl and delivery batch
+	// in case the lease was lost along the way. Nil means always.
+	isLeader func() bool
+
+	feedPicks feedPicks
+}
 
-	httpClient *http.Client
+func (b *Bot) leading() bool {
+	return b.isLeader == nil || b.isLeader()
 }
 
 func (b *Bot) AutocompleteCollectionName(s *discordgo.Session, i *discordgo.Interaction, option *discordgo.ApplicationCommandInteractionDataOption) {
@@ -87,16 +106,27 @@ func (b *Bot) Subscribe(s *discordgo.Session, i *discordgo.Interaction) {
 		return
 	}
 
-	channel := opts[optionChannel].ChannelValue(s)
 	collection := opts[optionCollectionName].StringValue()
 
+	var channel *discordgo.Channel
+	if opt, ok := opts[optionChannel]; ok {
+		channel = resolvedChannel(s, i.ApplicationCommandData(), opt)
+	}
+
+	var template string
+	if opt, ok := opts[optionTemplate]; ok {
+		template = opt.StringValue()
+	}
+
+	var crosspost, crosspostSet bool
+	if opt, ok := opts[optionCrosspost]; ok {
+		crosspost, crosspostSet = opt.BoolValue(), true
+	}
+
 	logger = logger.With(
-		slog.String("announce_channel_id", channel.ID),
 		slog.String("collection_name", collection),
 	)
 
-	var httpErr *ErrHTTP
-
 	respond := func(msg string) {
 		if err := b.respondToInteraction(s, i, msg); err != nil {
 			logger.With(slog.Any("err", err)).Error("respond to interaction")
@@ -104,40 +134,136 @@ func (b *Bot) Subscribe(s *discordgo.Session, i *discordgo.Interaction) {
 		}
 	}
 
-	err = b.subscribe(link, i.GuildID, channel.ID, collection)
+	if template != "" {
+		if _, err := parseAnnouncementTemplate(template); err != nil {
+			respond(fmt.Sprintf("🪿 cOnFuSeD hOnK! That message template doesn't work: %v", err))
+			return
+		}
+	}
+
+	c := Collection{
+		ServerID:  i.GuildID,
+		Name:      collection,
+		Template:  template,
+		Crosspost: crosspost,
+	}
+	if channel != nil {
+		c.ChannelID, c.ChannelType = channel.ID, channel.Type
+	}
+
+	existing, err := b.collections.GetByName(i.GuildID, collection)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if channel == nil {
+			respond(`🪿 cOnFuSeD hOnK! Which channel should the new collection be announced to?`)
+			return
+		}
+	case err != nil:
+		logger.With(slog.Any("err", err)).Error("get collection")
+		b.respondInternalError(s, i)
+		return
+	default:
+		// The feed joins the collection as it is. Changing the whole
+		// collection is what /template and /edit are for.
+		if template != "" || crosspostSet {
+			respond(fmt.Sprintf("🪿 cOnFuSeD hOnK! The %q collection already exists, so new feeds use its template and crosspost setting. Change those with /template and /edit.", collection))
+			return
+		}
+		if channel == nil {
+			c.ChannelID, c.ChannelType = existing.ChannelID, existing.ChannelType
+		}
+	}
+
+	if c.Crosspost && c.ChannelType != discordgo.ChannelTypeGuildNews {
+		respond(`🪿 cOnFuSeD hOnK! Only announcement channels can publish to other servers.`)
+		return
+	}
+
+	logger = logger.With(slog.String("announce_channel_id", c.ChannelID))
+
+	// Fetching the feed, and looking for it first if the URL is a web
+	// page, can take longer than Discord waits for a response, so the
+	// result is sent once it's known.
+	err = s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("defer response")
+		return
+	}
+
+	err = b.subscribe(link, c)
+
+	var multi *ErrMultipleFeeds
+	if errors.As(err, &multi) {
+		err := b.askFeedPick(s, i, c, multi.Links)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("edit response")
+		}
+		return
+	}
+
+	msg := subscribeResult(logger, err, c)
+	_, err = s.InteractionResponseEdit(i, &discordgo.WebhookEdit{Content: &msg})
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("edit response")
+	}
+}
+
+// subscribeResult phrases the outcome of subscribing to a feed.
+func subscribeResult(logger *slog.Logger, err error, c Collection) string {
+	var httpErr *ErrHTTP
+
 	switch {
 	case err == nil:
-		response := fmt.Sprintf("🪿 Affirmative HONK! I'll send new items in the %q collection to %s.", collection, channel.Mention())
-		respond(response)
+		return fmt.Sprintf("🪿 Affirmative HONK! I'll send new items in the %q collection to <#%s>.", c.Name, c.ChannelID)
 	case errors.Is(err, ErrAlreadyExists):
-		respond(`🪿 Smug HONK! You're already subscribed to that feed.`)
+		return fmt.Sprintf("🪿 Smug HONK! <#%s> already gets new items from that feed.", c.ChannelID)
+	case errors.Is(err, ErrCollectionNameTaken):
+		return fmt.Sprintf("🪿 NEGATIVE HONK! There's already a collection called %q announcing to another channel. Pick another name, or move it with /edit.", c.Name)
 	case errors.Is(err, ErrNotRSSFeed):
-		respond(`🪿 cOnFuSeD hOnK! There doesn't seem to be a valid RSS feed at that URL.`)
+		return `🪿 cOnFuSeD hOnK! There doesn't seem to be a valid RSS feed at that URL.`
+	case errors.Is(err, ErrFeedTooLarge):
+		return `🪿 overwhelmed honk. That feed is too big for me.`
 	case errors.As(err, &httpErr):
 		switch {
 		case httpErr.StatusCode == http.StatusUnauthorized:
-			respond(`🪿 rebuked honk. The website requires authorization to view that page.`)
+			return `🪿 rebuked honk. The website requires authorization to view that page.`
 		case httpErr.StatusCode == http.StatusForbidden:
-			respond(`🪿 rebuked honk. The website said viewing that resource is forbidden.`)
+			return `🪿 rebuked honk. The website said viewing that resource is forbidden.`
 		case httpErr.StatusCode == http.StatusNotFound:
-			respond(`🪿 lost honk. The website said there's nothing to be found at that URL.`)
+			return `🪿 lost honk. The website said there's nothing to be found at that URL.`
 		case httpErr.StatusCode >= 500:
-			respond(`🪿 Advisory honk: that website seems to be having issues, try adding this again later.`)
+			return `🪿 Advisory honk: that website seems to be having issues, try adding this again later.`
 		default:
 			logger.Error("Unexpected HTTP error", slog.Int("http_status_code", httpErr.StatusCode))
-			respond(`🪿 sad honk. I couldn't fetch that feed but it's my fault, so this could be a bug.`)
+			return `🪿 sad honk. I couldn't fetch that feed but it's my fault, so this could be a bug.`
 		}
 	default:
 		logger.With(slog.Any("err", err)).Error("internal error")
-		b.respondInternalError(s, i)
+		return internalErrorMessage
 	}
 }
 
-func (b *Bot) subscribe(link *url.URL, serverID, channelID, collection string) error {
+// subscribe adds the feed at link to a collection, creating the
+// collection if the server doesn't have one by that name yet and fetching
+// the feed for the first time if nobody else is subscribed to it. It
+// returns ErrCollectionNameTaken if the collection exists but announces
+// to a different channel.
+//
+// If link is a web page rather than a feed, the feed it leads to is
+// subscribed to instead. ErrMultipleFeeds is returned if it leads to
+// several.
+func (b *Bot) subscribe(link *url.URL, c Collection) error {
+	return b.subscribeFeed(link, c, true)
+}
+
+func (b *Bot) subscribeFeed(link *url.URL, c Collection, discover bool) error {
 	now := time.Now().UTC()
 
 	feed, err := b.feeds.GetByLink(link.String())
-	if errors.Is(err, ErrNotFound) {
+	switch {
+	case errors.Is(err, ErrNotFound):
 		req, err := http.NewRequest(http.MethodGet, link.String(), strings.NewReader(""))
 		if err != nil {
 			return fmt.Errorf("http new request: %w", err)
@@ -153,27 +279,119 @@ func (b *Bot) subscribe(link *url.URL, serverID, channelID, collection string) e
 			return &ErrHTTP{StatusCode: rsp.StatusCode}
 		}
 
-		feedContents, err := gofeed.NewParser().Parse(rsp.Body)
-		if errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+		body, err := io.ReadAll(io.LimitReader(rsp.Body, feedSizeMax+1))
+		if err != nil {
+			return fmt.Errorf("read feed: %w", err)
+		}
+		if len(body) > feedSizeMax {
+			return ErrFeedTooLarge
+		}
+
+		feedContents, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+		if errors.Is(err, gofeed.ErrFeedTypeNotDetected) && discover {
+			links := b.discoverFeeds(rsp.Request.URL, body)
+			switch len(links) {
+			case 0:
+				return ErrNotRSSFeed
+			case 1:
+				return b.subscribeFeed(links[0], c, false)
+			default:
+				return &ErrMultipleFeeds{Links: links}
+			}
+		}
+		if err != nil {
 			return ErrNotRSSFeed
 		}
-		sort.Sort(feedContents)
 
 		now := time.Now().UTC()
-		notUntil := calculateNotUntil(rsp, now)
+		notUntil, reason := b.cachePolicy.NotUntil(rsp, now)
 
 		feed, err = b.feeds.Create(link, notUntil)
+		if errors.Is(err, ErrAlreadyExists) {
+			// Someone else subscribed to the feed in the meantime and
+			// already added what's on it.
+			feed, err = b.feeds.GetByLink(link.String())
+			if err != nil {
+				return fmt.Errorf("get feed: %w", err)
+			}
+			break
+		}
 		if err != nil {
 			return fmt.Errorf("create feed: %w", err)
 		}
 
-		err = b.refreshFeed(feed, feedContents, time.Time{})
+		feed.Title = feedContents.Title
+		feed.NotUntilReason = reason
+		feed.ETag = rsp.Header.Get("ETag")
+		feed.LastModified = rsp.Header.Get("Last-Modified")
+		recordFeedSuccess(feed, now)
+		err = b.feeds.Update(feed)
+		if err != nil {
+			return fmt.Errorf("update feed: %w", err)
+		}
+
+		err = b.refreshFeed(feed, feedContents, time.Time{}, now)
 		if err != nil {
 			return fmt.Errorf("refresh feed: %w", err)
 		}
+	case err != nil:
+		return fmt.Errorf("get feed: %w", err)
+	case feed.Disabled:
+		// Subscribing to a feed that was disabled after repeated
+		// failures gives it a fresh start on the next crawl.
+		feed.Disabled = false
+		feed.ConsecutiveFailures = 0
+		feed.NotUntil = now
+		err = b.feeds.Update(feed)
+		if err != nil {
+			return fmt.Errorf("re-enable feed: %w", err)
+		}
+	}
+
+	created := false
+	collection, err := b.collections.GetByName(c.ServerID, c.Name)
+	if errors.Is(err, ErrNotFound) {
+		collection, err = b.collections.Create(c)
+		created = err == nil
+	}
+	if err != nil {
+		return fmt.Errorf("get collection: %w", err)
+	}
+
+	err = b.addFeed(collection, c.ChannelID, feed.ID, now)
+	if err != nil && created {
+		// Don't leave behind an empty collection nobody asked for.
+		if err := b.collections.Delete(collection.ID); err != nil {
+			slog.With(slog.Int64("collection_id", collection.ID), slog.Any("err", err)).Error("delete new collection")
+		}
+	}
+
+	return err
+}
+
+// addFeed puts a feed into collection unless channelID isn't where the
+// collection announces, in which case ErrCollectionNameTaken is returned,
+// or the channel already gets the feed's items through another collection,
+// in which case ErrAlreadyExists is returned. Adding a feed to a collection
+// it's already in does nothing.
+func (b *Bot) addFeed(collection *Collection, channelID string, feedID int64, now time.Time) error {
+	if collection.ChannelID != channelID {
+		return ErrCollectionNameTaken
 	}
 
-	_, err = b.subscriptions.Create(feed.ID, serverID, channelID, collection, now)
+	reaches, err := b.feedReachesChannel(feedID, channelID, collection.ID)
+	if err != nil {
+		return err
+	}
+	if reaches {
+		return ErrAlreadyExists
+	}
+
+	_, err = b.subscriptions.Create(Subscription{
+		CollectionID: collection.ID,
+		FeedID:       feedID,
+		LastPubDate:  now,
+	})
 	if err != nil && !errors.Is(err, ErrAlreadyExists) {
 		return fmt.Errorf("create subscription: %w", err)
 	}
@@ -181,47 +399,122 @@ func (b *Bot) subscribe(link *url.URL, serverID, channelID, collection string) e
 	return nil
 }
 
+// feedReachesChannel reports whether a collection other than
+// collectionID already announces the feed's items to channelID. Every
+// item would be posted twice if another one did too.
+func (b *Bot) feedReachesChannel(feedID int64, channelID string, collectionID int64) (bool, error) {
+	subs, err := b.subscriptions.ListByFeed(feedID)
+	if err != nil {
+		return false, fmt.Errorf("list subscriptions of feed %d: %w", feedID, err)
+	}
+
+	for _, sub := range subs {
+		if sub.CollectionID == collectionID {
+			continue
+		}
+
+		other, err := b.collections.Get(sub.CollectionID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("get collection %d: %w", sub.CollectionID, err)
+		}
+
+		if other.ChannelID == channelID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (b *Bot) Unsubscribe(s *discordgo.Session, i *discordgo.Interaction) {
 	opts := optionsToMap(i.ApplicationCommandData().Options)
 	collection := opts[optionCollectionName].StringValue()
 
+	var feed string
+	if opt, ok := opts[optionFeed]; ok {
+		feed = opt.StringValue()
+	}
+
 	logger := slog.With(
 		slog.String("interaction_id", i.ID),
 		slog.String("guild_id", i.GuildID),
 		slog.String("channel_id", i.ChannelID),
 		slog.String("collection_name", collection),
+		slog.String("feed", feed),
 	)
 
-	err := b.unsubscribe(i.GuildID, collection)
-	if errors.Is(err, ErrNotFound) {
-		response := fmt.Sprintf("🪿 lost honk. I couldn't find a subscription with the collection name %q", collection)
-		err := b.respondToInteraction(s, i, response)
-		if err != nil {
+	respond := func(msg string) {
+		if err := b.respondToInteraction(s, i, msg); err != nil {
 			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
 		}
-		return
 	}
-	if err != nil {
+
+	var link *url.URL
+	if feed != "" {
+		var err error
+		link, err = url.Parse(feed)
+		if err != nil {
+			respond(`🪿 cOnFuSeD hOnK! Is that a valid URL?`)
+			return
+		}
+	}
+
+	removed, err := b.unsubscribe(i.GuildID, collection, link)
+	switch {
+	case errors.Is(err, ErrNotFound) && link == nil:
+		respond(fmt.Sprintf("🪿 lost honk. I couldn't find a subscription with the collection name %q", collection))
+	case errors.Is(err, ErrNotFound):
+		respond(fmt.Sprintf("🪿 lost honk. I couldn't find that feed in the %q collection", collection))
+	case err != nil:
 		logger.With(slog.Any("err", err)).Error("unsubscribe")
 		b.respondInternalError(s, i)
-		return
+	case removed:
+		respond(fmt.Sprintf("🪿 Affirmative HONK! I removed the %q collection", collection))
+	default:
+		respond(fmt.Sprintf("🪿 Affirmative HONK! I removed that feed from %q", collection))
 	}
+}
 
-	response := fmt.Sprintf("🪿 Affirmative HONK! I removed the subscription to %q", collection)
-	err = b.respondToInteraction(s, i, response)
+// unsubscribe removes a collection, or only the feed at link if it isn't
+// nil. It reports whether the whole collection was removed, which also
+// happens when its last feed is.
+func (b *Bot) unsubscribe(serverID, collectionName string, link *url.URL) (bool, error) {
+	collection, err := b.collections.GetByName(serverID, collectionName)
 	if err != nil {
-		logger.With(slog.Any("err", err)).Error("respond to interaction")
-		return
+		return false, err
+	}
+
+	if link == nil {
+		return true, b.collections.Delete(collection.ID)
+	}
+
+	feed, err := b.feeds.GetByLink(link.String())
+	if err != nil {
+		return false, err
 	}
-}
 
-func (b *Bot) unsubscribe(serverID, collectionName string) error {
-	sub, err := b.subscriptions.GetByCollectionName(serverID, collectionName)
+	subs, err := b.subscriptions.ListByCollection(collection.ID)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	for _, sub := range subs {
+		if sub.FeedID != feed.ID {
+			continue
+		}
+
+		if len(subs) == 1 {
+			return true, b.collections.Delete(collection.ID)
+		}
+
+		return false, b.subscriptions.Delete(sub.ID)
 	}
 
-	return b.subscriptions.Delete(sub.ID)
+	return false, ErrNotFound
 }
 
 func (b *Bot) Test(s *discordgo.Session, i *discordgo.Interaction) {
@@ -235,7 +528,7 @@ func (b *Bot) Test(s *discordgo.Session, i *discordgo.Interaction) {
 		slog.String("collection_name", collection),
 	)
 
-	link, err := b.test(i.GuildID, collection)
+	latest, feed, err := b.test(i.GuildID, collection)
 	switch {
 	case err == nil:
 		err = b.rateLimiter.Wait(context.Background())
@@ -244,8 +537,8 @@ func (b *Bot) Test(s *discordgo.Session, i *discordgo.Interaction) {
 			return
 		}
 
-		message := fmt.Sprintf("🪿 TEST HONK! Here's the latest item from the %q collection: %s", collection, link)
-		err := b.respondToInteraction(s, i, message)
+		message := fmt.Sprintf("🪿 TEST HONK! Here's the latest item from the %q collection:", collection)
+		err := b.respondToInteractionWithEmbed(s, i, message, articleEmbed(latest, feed.Title))
 		if err != nil {
 			logger.With(slog.Any("err", err)).Error("respond to interaction")
 			return
@@ -258,7 +551,7 @@ func (b *Bot) Test(s *discordgo.Session, i *discordgo.Interaction) {
 			return
 		}
 	case errors.Is(err, ErrEmptyFeed):
-		message := "🪿 sad honk... There are no items in that RSS feed."
+		message := "🪿 sad honk... There are no items in that collection's feeds."
 		err := b.respondToInteraction(s, i, message)
 		if err != nil {
 			logger.With(slog.Any("err", err)).Error("respond to interaction")
@@ -269,242 +562,895 @@ func (b *Bot) Test(s *discordgo.Session, i *discordgo.Interaction) {
 	}
 }
 
-func (b *Bot) test(serverID, collectionName string) (string, error) {
-	sub, err := b.subscriptions.GetByCollectionName(serverID, collectionName)
-	if err != nil {
-		return "", err
-	}
-
-	latest, err := b.articles.Latest(sub.FeedID)
-	if errors.Is(err, ErrNotFound) {
-		return "", ErrEmptyFeed
-	}
+// test finds the latest item on any of a collection's feeds.
+func (b *Bot) test(serverID, collectionName string) (*Article, *Feed, error) {
+	collection, err := b.collections.GetByName(serverID, collectionName)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	return latest.Link, nil
-}
-
-func (b *Bot) Update(ctx context.Context) error {
-	nots, err := b.subscriptions.PendingNotifications()
+	subs, err := b.subscriptions.ListByCollection(collection.ID)
 	if err != nil {
-		slog.With(slog.Any("err", err)).Error("fetch notifications")
-		return err
-	}
-
-	if len(nots) == 0 {
-		slog.Info("No pending notifications to send out")
-		return nil
+		return nil, nil, err
 	}
 
-	for _, n := range nots {
-		logger := slog.With(
-			slog.Int64("subscription_id", n.SubscriptionID),
-			slog.Int64("article_id", n.ArticleID),
-			slog.String("guild_id", n.ServerID),
-			slog.String("channel_id", n.ChannelID),
-			slog.String("collection_name", n.CollectionName),
-		)
-
-		err := b.rateLimiter.Wait(ctx)
-		if err != nil {
-			return err
+	var latest *Article
+	for _, sub := range subs {
+		article, err := b.articles.Latest(sub.FeedID)
+		if errors.Is(err, ErrNotFound) {
+			continue
 		}
-
-		message := fmt.Sprintf("🪿 HONK! New item from collection %q: %s", n.CollectionName, n.Link)
-		_, err = b.session.ChannelMessageSend(n.ChannelID, message)
 		if err != nil {
-			logger.With(slog.Any("err", err)).Error("send message to channel")
-			continue
+			return nil, nil, err
 		}
 
-		err = b.subscriptions.UpdateLastPubDate(n.SubscriptionID, n.PubDate)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("update last pub date")
-			continue
+		if latest == nil || article.Published.After(latest.Published) {
+			latest = article
 		}
 	}
 
-	return nil
-}
-
-func (b *Bot) RefreshFeeds(ctx context.Context) error {
-	now := time.Now().UTC()
-
-	feeds, err := b.feeds.ListReady(now)
-	if err != nil {
-		return fmt.Errorf("feeds.ListReady: %w", err)
+	if latest == nil {
+		return nil, nil, ErrEmptyFeed
 	}
 
-	if len(feeds) == 0 {
-		slog.Info("no eligible feeds to refresh")
-		return nil
+	feed, err := b.feeds.Get(latest.FeedID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	slog.With(slog.Int("num_feeds", len(feeds))).Info("Refreshing eligible feeds")
-
-	for _, feed := range feeds {
-		logger := slog.With(
-			slog.String("request_url", feed.Link),
-			slog.Int64("feed_id", feed.ID),
-		)
-
-		req, err := http.NewRequest(http.MethodGet, feed.Link, strings.NewReader(""))
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("form GET")
-			continue
-		}
-
-		rsp, err := b.httpClient.Do(req)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("HTTP GET")
-			continue
-		}
-		defer rsp.Body.Close()
-
-		notUntil := calculateNotUntil(rsp, now)
-
-		feed.NotUntil = notUntil
-		err = b.feeds.Update(&feed)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("update not until")
-			continue
-		}
-
-		feedContents, err := gofeed.NewParser().Parse(rsp.Body)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("parse feed")
-			continue
-		}
+	return latest, feed, nil
+}
 
-		latestPub := time.Time{}
-		if article, err := b.articles.Latest(feed.ID); err == nil {
-			latestPub = article.Published
-		} else if !errors.Is(err, ErrNotFound) {
-			logger.With(slog.Any("err", err)).Error("get latest article")
-		}
+func (b *Bot) Template(s *discordgo.Session, i *discordgo.Interaction) {
+	opts := optionsToMap(i.ApplicationCommandData().Options)
+	collection := opts[optionCollectionName].StringValue()
 
-		err = b.refreshFeed(&feed, feedContents, latestPub)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("refresh feed")
-			continue
-		}
+	var template string
+	if opt, ok := opts[optionTemplate]; ok {
+		template = opt.StringValue()
 	}
 
-	return nil
-}
-
-func (b *Bot) refreshFeed(feed *Feed, feedContents *gofeed.Feed, since time.Time) error {
 	logger := slog.With(
-		slog.String("request_url", feed.Link),
-		slog.Int64("feed_id", feed.ID),
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+		slog.String("collection_name", collection),
 	)
 
-	sort.Sort(feedContents)
-
-	for _, item := range feedContents.Items {
-		if item.PublishedParsed == nil {
-			continue
-		}
-
-		if item.PublishedParsed.UTC().Before(since.UTC()) {
-			continue
+	respond := func(msg string) {
+		if err := b.respondToInteraction(s, i, msg); err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
 		}
+	}
 
-		u, err := url.Parse(item.Link)
-		if err != nil {
-			logger.With(slog.Any("err", err)).Warn("parse URL")
-			continue
+	if template != "" {
+		if _, err := parseAnnouncementTemplate(template); err != nil {
+			respond(fmt.Sprintf("🪿 cOnFuSeD hOnK! That message template doesn't work: %v", err))
+			return
 		}
+	}
 
-		article, err := b.articles.Create(feed.ID, item.Title, u, item.PublishedParsed.UTC())
-		if errors.Is(err, ErrAlreadyExists) {
-			continue
-		}
-		if err != nil {
-			logger.With(slog.Any("err", err)).Error("add new article")
-			continue
-		}
+	err := b.template(i.GuildID, collection, template)
+	switch {
+	case err == nil && template == "":
+		respond(fmt.Sprintf("🪿 Affirmative HONK! New items in the %q collection will be announced the usual way.", collection))
+	case err == nil:
+		respond(fmt.Sprintf("🪿 Affirmative HONK! New items in the %q collection will be announced with your template.", collection))
+	case errors.Is(err, ErrNotFound):
+		respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+	default:
+		logger.With(slog.Any("err", err)).Error("update template")
+		b.respondInternalError(s, i)
+	}
+}
 
-		logger.With(slog.Int64("article_id", article.ID)).Info("Added new article")
+func (b *Bot) template(serverID, collectionName, template string) error {
+	collection, err := b.collections.GetByName(serverID, collectionName)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return b.collections.UpdateTemplate(collection.ID, template)
 }
 
-func (b *Bot) respondInternalError(s *discordgo.Session, i *discordgo.Interaction) {
+func (b *Bot) Edit(s *discordgo.Session, i *discordgo.Interaction) {
+	opts := optionsToMap(i.ApplicationCommandData().Options)
+	collection := opts[optionCollectionName].StringValue()
+
 	logger := slog.With(
 		slog.String("interaction_id", i.ID),
 		slog.String("guild_id", i.GuildID),
 		slog.String("channel_id", i.ChannelID),
+		slog.String("collection_name", collection),
 	)
-	err := b.respondToInteraction(s, i, `🪿 ashamed honk. I ran into an issue processing this request. I have failed you. This might be a bug.`)
-	if err != nil {
-		logger.With(slog.Any("err", err)).Error("respond with internal error")
+
+	respond := func(msg string) {
+		if err := b.respondToInteraction(s, i, msg); err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
+		}
+	}
+
+	var channel *discordgo.Channel
+	if opt, ok := opts[optionChannel]; ok {
+		channel = resolvedChannel(s, i.ApplicationCommandData(), opt)
+	}
+
+	var name string
+	if opt, ok := opts[optionName]; ok {
+		name = strings.TrimSpace(opt.StringValue())
+	}
+
+	var crosspost *bool
+	if opt, ok := opts[optionCrosspost]; ok {
+		v := opt.BoolValue()
+		crosspost = &v
 	}
+
+	var updateMode UpdateMode
+	if opt, ok := opts[optionUpdates]; ok {
+		updateMode = UpdateMode(opt.StringValue())
+		if !updateMode.valid() {
+			respond(`🪿 cOnFuSeD hOnK! I don't know that way of following updates.`)
+			return
+		}
+	}
+
+	if channel == nil && name == "" && crosspost == nil && updateMode == "" {
+		respond(`🪿 cOnFuSeD hOnK! Tell me a new channel, name, crosspost or updates setting for the collection.`)
+		return
+	}
+
+	c, err := b.collections.GetByName(i.GuildID, collection)
+	if errors.Is(err, ErrNotFound) {
+		respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+		return
+	}
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("get collection")
+		b.respondInternalError(s, i)
+		return
+	}
+
+	channelID, channelType := c.ChannelID, c.ChannelType
+	if channel != nil {
+		channelID, channelType = channel.ID, channel.Type
+	}
+
+	// Moving to a channel that can't crosspost quietly turns it off,
+	// unless crossposting was asked for.
+	wantCrosspost := c.Crosspost && channelType == discordgo.ChannelTypeGuildNews
+	if crosspost != nil {
+		wantCrosspost = *crosspost
+	}
+
+	if wantCrosspost && channelType != discordgo.ChannelTypeGuildNews {
+		respond(`🪿 cOnFuSeD hOnK! Only announcement channels can publish to other servers.`)
+		return
+	}
+
+	logger = logger.With(
+		slog.Int64("collection_id", c.ID),
+		slog.String("announce_channel_id", channelID),
+		slog.String("new_collection_name", name),
+	)
+
+	if channelID != c.ChannelID {
+		link, err := b.sharedFeed(c.ID, channelID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("check channel for feeds")
+			b.respondInternalError(s, i)
+			return
+		}
+		if link != "" {
+			respond(fmt.Sprintf("🪿 Smug HONK! <#%s> already gets new items from %s through another collection.", channelID, link))
+			return
+		}
+	}
+
+	// Renaming is the change most likely to be refused, so it goes first
+	// to avoid leaving the collection half edited.
+	if name != "" && name != c.Name {
+		err = b.collections.Rename(c.ID, name)
+		switch {
+		case err == nil:
+			collection = name
+		case errors.Is(err, ErrCollectionNameTaken):
+			respond(fmt.Sprintf("🪿 NEGATIVE HONK! There's already a collection called %q in this server, pick another name.", name))
+			return
+		case errors.Is(err, ErrNotFound):
+			respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+			return
+		default:
+			logger.With(slog.Any("err", err)).Error("rename collection")
+			b.respondInternalError(s, i)
+			return
+		}
+	}
+
+	if channelID != c.ChannelID || channelType != c.ChannelType || wantCrosspost != c.Crosspost {
+		err = b.collections.UpdateChannel(c.ID, channelID, channelType, wantCrosspost)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNotFound):
+			respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+			return
+		default:
+			logger.With(slog.Any("err", err)).Error("update collection channel")
+			b.respondInternalError(s, i)
+			return
+		}
+	}
+
+	if updateMode != "" && updateMode != c.UpdateMode {
+		err = b.collections.SetUpdateMode(c.ID, updateMode)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNotFound):
+			respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+			return
+		default:
+			logger.With(slog.Any("err", err)).Error("set collection update mode")
+			b.respondInternalError(s, i)
+			return
+		}
+	}
+
+	msg := fmt.Sprintf("🪿 Affirmative HONK! I'll send new items in the %q collection to <#%s>.", collection, channelID)
+	switch updateMode {
+	case UpdateModeOff:
+		msg += " I'll leave items alone once they're announced."
+	case UpdateModeEdit:
+		msg += " When a feed changes an item, I'll edit its announcement."
+	case UpdateModeNotice:
+		msg += " When a feed changes an item, I'll announce that it was updated."
+	}
+
+	respond(msg)
 }
 
-func (b *Bot) respondToInteraction(s *discordgo.Session, i *discordgo.Interaction, message string) error {
-	return s.InteractionRespond(i, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: message,
-		},
-	})
+// sharedFeed returns the link of a feed in the collection that another
+// collection already announces to channelID, or "" if there's none.
+func (b *Bot) sharedFeed(collectionID int64, channelID string) (string, error) {
+	subs, err := b.subscriptions.ListByCollection(collectionID)
+	if err != nil {
+		return "", fmt.Errorf("list subscriptions of collection %d: %w", collectionID, err)
+	}
+
+	for _, sub := range subs {
+		reaches, err := b.feedReachesChannel(sub.FeedID, channelID, collectionID)
+		if err != nil {
+			return "", err
+		}
+		if !reaches {
+			continue
+		}
+
+		feed, err := b.feeds.Get(sub.FeedID)
+		if err != nil {
+			return "", fmt.Errorf("get feed %d: %w", sub.FeedID, err)
+		}
+		return feed.Link, nil
+	}
+
+	return "", nil
 }
 
-func optionsToMap(opts []*discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
-	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(opts))
-	for _, opt := range opts {
-		options[opt.Name] = opt
+func (b *Bot) Filter(s *discordgo.Session, i *discordgo.Interaction) {
+	subcommand := i.ApplicationCommandData().Options[0]
+	opts := optionsToMap(subcommand.Options)
+	collection := opts[optionCollectionName].StringValue()
+
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+		slog.String("collection_name", collection),
+		slog.String("subcommand", subcommand.Name),
+	)
+
+	respond := func(msg string) {
+		if err := b.respondToInteraction(s, i, msg); err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
+		}
 	}
-	return options
+
+	c, err := b.collections.GetByName(i.GuildID, collection)
+	if errors.Is(err, ErrNotFound) {
+		respond("🪿 NEGATIVE HONK! Did not find a collection with that name.")
+		return
+	}
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("get collection")
+		b.respondInternalError(s, i)
+		return
+	}
+
+	switch subcommand.Name {
+	case subcommandAdd:
+		filter := Filter{
+			CollectionID: c.ID,
+			Field:        filterFieldAny,
+			Mode:         opts[optionFilterMode].StringValue(),
+			Kind:         filterKindKeyword,
+			Pattern:      opts[optionFilterPattern].StringValue(),
+		}
+		if opt, ok := opts[optionFilterField]; ok {
+			filter.Field = opt.StringValue()
+		}
+		if opt, ok := opts[optionFilterKind]; ok {
+			filter.Kind = opt.StringValue()
+		}
+
+		created, err := b.addFilter(filter)
+		switch {
+		case err == nil:
+			respond(fmt.Sprintf("🪿 Affirmative HONK! Added filter `#%d` (%s) to the %q collection.", created.ID, created, collection))
+		case errors.Is(err, ErrInvalidFilter):
+			respond(fmt.Sprintf("🪿 cOnFuSeD hOnK! That filter doesn't work: %v", err))
+		case errors.Is(err, ErrTooManyFilters):
+			respond(fmt.Sprintf("🪿 overwhelmed honk. A collection can have at most %d filters.", filtersPerCollectionMax))
+		default:
+			logger.With(slog.Any("err", err)).Error("add filter")
+			b.respondInternalError(s, i)
+		}
+	case subcommandRemove:
+		id := opts[optionFilterID].IntValue()
+
+		err := b.filters.Delete(c.ID, id)
+		switch {
+		case err == nil:
+			respond(fmt.Sprintf("🪿 Affirmative HONK! Removed filter `#%d` from the %q collection.", id, collection))
+		case errors.Is(err, ErrNotFound):
+			respond(fmt.Sprintf("🪿 lost honk. The %q collection doesn't have a filter `#%d`.", collection, id))
+		default:
+			logger.With(slog.Any("err", err)).Error("remove filter")
+			b.respondInternalError(s, i)
+		}
+	case subcommandList:
+		filters, err := b.filters.ListByCollection(c.ID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("list filters")
+			b.respondInternalError(s, i)
+			return
+		}
+
+		if len(filters) == 0 {
+			respond(fmt.Sprintf("🪿 The %q collection has no filters, so every new item is announced.", collection))
+			return
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "🪿 Filters on the %q collection:", collection)
+		for _, f := range filters {
+			fmt.Fprintf(&sb, "\n`#%d` %s", f.ID, &f)
+		}
+		respond(truncate(sb.String(), messageContentLimit))
+	}
+}
+
+func (b *Bot) addFilter(filter Filter) (*Filter, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := b.filters.ListByCollection(filter.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(existing) >= filtersPerCollectionMax {
+		return nil, ErrTooManyFilters
+	}
+
+	return b.filters.Create(filter)
+}
+
+func (b *Bot) Update(ctx context.Context) error {
+	filterSets := make(map[int64]*FilterSet)
+	delivered := 0
+
+	for {
+		if !b.leading() {
+			slog.Warn("No longer leader, leaving the remaining notifications to the new one")
+			return nil
+		}
+
+		nots, err := b.deliveries.Claim(time.Now().UTC(), deliveryLease, deliveryBatchSize)
+		if err != nil {
+			slog.With(slog.Any("err", err)).Error("claim notifications")
+			return err
+		}
+
+		if len(nots) == 0 {
+			if delivered == 0 {
+				slog.Info("No pending notifications to send out")
+			}
+			return nil
+		}
+
+		for _, n := range nots {
+			err = b.deliver(ctx, &n, filterSets)
+			if err != nil {
+				return err
+			}
+		}
+
+		delivered += len(nots)
+	}
+}
+
+// deliver announces a claimed notification and records the outcome. It
+// only returns an error if ctx is done; anything else is recorded on the
+// delivery to be retried.
+func (b *Bot) deliver(ctx context.Context, n *Notification, filterSets map[int64]*FilterSet) error {
+	logger := slog.With(
+		slog.Int64("delivery_id", n.DeliveryID),
+		slog.Int64("subscription_id", n.SubscriptionID),
+		slog.Int64("article_id", n.Article.ID),
+		slog.String("guild_id", n.ServerID),
+		slog.String("channel_id", n.ChannelID),
+		slog.String("collection_name", n.CollectionName),
+	)
+
+	fs, ok := filterSets[n.CollectionID]
+	if !ok {
+		var err error
+		fs, err = b.filterSet(n.CollectionID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("load filters")
+			b.retryDelivery(n, err)
+			return nil
+		}
+		filterSets[n.CollectionID] = fs
+	}
+
+	if n.Update {
+		return b.deliverUpdate(ctx, logger, n, fs)
+	}
+
+	if !fs.Allows(&n.Article) {
+		logger.Info("Skipping filtered item")
+
+		// The item wasn't announced, so it doesn't count as the last
+		// announced one either.
+		err := b.deliveries.Skip(n.DeliveryID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("skip delivery")
+		}
+		return nil
+	}
+
+	err := b.rateLimiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := announcement(logger, n)
+	messageID, err := b.send(n.ServerID, n.ChannelID, n.ChannelType, n.Crosspost, n.Article.Title, message)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("send message to channel")
+		b.retryDelivery(n, err)
+		return nil
+	}
+
+	// If this fails the delivery is retried once its lease runs out,
+	// which is the only way an item can be announced twice.
+	err = b.deliveries.Sent(n.DeliveryID, messageChannel(n.ChannelID, n.ChannelType, messageID), messageID)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("mark delivery sent")
+		return nil
+	}
+
+	err = b.subscriptions.UpdateLastPubDate(n.SubscriptionID, n.Article.Published)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("update last pub date")
+	}
+
+	return nil
 }
 
-func calculateNotUntil(r *http.Response, now time.Time) time.Time {
-	min := func(a, b int64) int64 {
-		if a > b {
-			return b
+// deliverUpdate tells a collection that an article it already announced
+// has changed, the way the collection asked to be told.
+func (b *Bot) deliverUpdate(ctx context.Context, logger *slog.Logger, n *Notification, fs *FilterSet) error {
+	logger = logger.With(
+		slog.String("message_id", n.MessageID),
+		slog.String("message_channel_id", n.MessageChannel),
+		slog.String("update_mode", string(n.UpdateMode)),
+	)
+
+	// The collection stopped following updates after this one was
+	// queued, or the item no longer passes its filters, so the
+	// announcement stays as it is.
+	if n.UpdateMode == UpdateModeOff || !fs.Allows(&n.Article) {
+		err := b.deliveries.Sent(n.DeliveryID, n.MessageChannel, n.MessageID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("mark delivery sent")
 		}
-		return a
+		return nil
 	}
 
-	parseInt64OrDefault := func(s string, defaultValue int64) int64 {
-		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-			return i
+	err := b.rateLimiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := announcement(logger, n)
+	notice := fmt.Sprintf("🪿 HONK! Updated item from collection %q", n.CollectionName)
+
+	// The announcement is edited in the channel it was made in, even if
+	// the collection has moved since. Notices reply to it, which they can
+	// only do while it's still where the collection announces.
+	channelID, messageID := n.MessageChannel, n.MessageID
+	inPlace := channelID == n.ChannelID || n.ChannelType == discordgo.ChannelTypeGuildForum && channelID == messageID
+
+	fresh := false
+	switch {
+	case messageID == "":
+		fresh = true
+	case n.UpdateMode == UpdateModeEdit:
+		err = b.edit(n.ServerID, channelID, messageID, message)
+		fresh = undeliverable(err)
+	case inPlace:
+		message.Content = notice
+		err = b.sendNotice(n.ServerID, channelID, messageID, message)
+		fresh = undeliverable(err)
+	default:
+		fresh = true
+	}
+
+	// A fresh notice in the collection's channel stands in for an
+	// announcement that is gone or out of reach, e.g. because it was in a
+	// channel that was deleted after the collection moved.
+	if fresh {
+		message.Content = notice
+		message.Reference = nil
+		messageID, err = b.send(n.ServerID, n.ChannelID, n.ChannelType, false, n.Article.Title, message)
+		channelID = messageChannel(n.ChannelID, n.ChannelType, messageID)
+	}
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("announce updated item")
+		b.retryDelivery(n, err)
+		return nil
+	}
+
+	// The announcement, or the notice that took its place, is the one to
+	// edit next time.
+	err = b.deliveries.Sent(n.DeliveryID, channelID, messageID)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("mark delivery sent")
+	}
+
+	return nil
+}
+
+// announcement renders the message that announces a notification's
+// article.
+func announcement(logger *slog.Logger, n *Notification) *discordgo.MessageSend {
+	content, err := renderAnnouncement(n)
+	if err != nil {
+		// The template was valid when it was saved, so fall back to
+		// the default rather than dropping the announcement.
+		logger.With(slog.Any("err", err)).Warn("render announcement template")
+		n.Template = ""
+		content, _ = renderAnnouncement(n)
+	}
+
+	return &discordgo.MessageSend{
+		Content: content,
+		Embeds:  []*discordgo.MessageEmbed{articleEmbed(&n.Article, n.FeedTitle)},
+	}
+}
+
+// retryDelivery backs off a delivery that failed, or gives up on it if it
+// can't succeed or has been attempted too many times.
+func (b *Bot) retryDelivery(n *Notification, cause error) {
+	logger := slog.With(
+		slog.Int64("delivery_id", n.DeliveryID),
+		slog.Int("attempts", n.Attempts),
+	)
+
+	var err error
+	if undeliverable(cause) || n.Attempts >= maxDeliveryAttempts {
+		logger.Warn("Giving up on delivery")
+		err = b.deliveries.Fail(n.DeliveryID, cause)
+	} else {
+		err = b.deliveries.Retry(n.DeliveryID, cause, time.Now().UTC().Add(deliveryBackoff(n.Attempts)))
+	}
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("record delivery failure")
+	}
+}
+
+func (b *Bot) filterSet(collectionID int64) (*FilterSet, error) {
+	filters, err := b.filters.ListByCollection(collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFilterSet(filters)
+}
+
+func (b *Bot) RefreshFeeds(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	feeds, err := b.feeds.ListReady(now)
+	if err != nil {
+		return fmt.Errorf("feeds.ListReady: %w", err)
+	}
+
+	if len(feeds) == 0 {
+		slog.Info("no eligible feeds to refresh")
+		return nil
+	}
+
+	slog.With(slog.Int("num_feeds", len(feeds))).Info("Refreshing eligible feeds")
+
+	b.crawler.Run(ctx, feeds, func(ctx context.Context, feed *Feed) {
+		// Once the lease is lost another process takes over crawling,
+		// so stop rather than crawl the same feeds alongside it.
+		if !b.leading() {
+			return
+		}
+		b.refresh(ctx, feed, time.Now().UTC())
+	})
+
+	return nil
+}
+
+// refresh crawls a single feed and persists the outcome, disabling the
+// feed if it has failed too many times in a row.
+func (b *Bot) refresh(ctx context.Context, feed *Feed, now time.Time) {
+	logger := slog.With(
+		slog.String("request_url", feed.Link),
+		slog.Int64("feed_id", feed.ID),
+	)
+
+	disabled := false
+
+	err := b.crawl(ctx, feed, now)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("crawl feed")
+		disabled = b.recordFeedFailure(feed, err, now)
+	} else {
+		recordFeedSuccess(feed, now)
+	}
+
+	err = b.feeds.Update(feed)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("update feed")
+		return
+	}
+
+	if disabled {
+		logger.With(slog.Int("consecutive_failures", feed.ConsecutiveFailures)).Warn("Disabled failing feed")
+
+		err = b.notifyFeedDisabled(ctx, feed)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("notify feed disabled")
+			return
 		}
-		return defaultValue
 	}
+}
+
+// crawl fetches feed and ingests any new articles. The feed's crawl
+// schedule and cache validators are updated in place but not persisted.
+func (b *Bot) crawl(ctx context.Context, feed *Feed, now time.Time) error {
+	logger := slog.With(
+		slog.String("request_url", feed.Link),
+		slog.Int64("feed_id", feed.ID),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.Link, strings.NewReader(""))
+	if err != nil {
+		return fmt.Errorf("form GET: %w", err)
+	}
+
+	setConditionalHeaders(req, feed)
+
+	rsp, err := b.crawler.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get feed: %w", err)
+	}
+	defer rsp.Body.Close()
+
+	feed.NotUntil, feed.NotUntilReason = b.cachePolicy.NotUntil(rsp, now)
+
+	if rsp.StatusCode == http.StatusNotModified {
+		logger.Info("Feed not modified since last crawl")
+		return nil
+	}
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return &ErrHTTP{StatusCode: rsp.StatusCode}
+	}
+
+	// A feed that outgrew the limit fails for that reason rather than as
+	// whatever the parser makes of its first feedSizeMax bytes.
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, feedSizeMax+1))
+	if err != nil {
+		return fmt.Errorf("read feed: %w", err)
+	}
+	if len(body) > feedSizeMax {
+		return fmt.Errorf("%w: over %d MiB", ErrFeedTooLarge, feedSizeMax>>20)
+	}
+
+	feedContents, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("parse feed: %w", err)
+	}
+
+	feed.Title = feedContents.Title
+	feed.ETag = rsp.Header.Get("ETag")
+	feed.LastModified = rsp.Header.Get("Last-Modified")
 
-	var maxAge, sMaxAge int64
+	return b.refreshFeed(feed, feedContents, b.cutoff(now), now)
+}
+
+// cutoff is the date before which items goose hasn't seen yet are too old
+// to announce at now.
+func (b *Bot) cutoff(now time.Time) time.Time {
+	if b.lookback <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-b.lookback)
+}
+
+// defaultLookback is how far back new items are announced by default.
+const defaultLookback = 7 * 24 * time.Hour
+
+// refreshFeed adds the items on a feed that goose hasn't seen yet, which
+// queues their announcements, and records changes to the ones it has.
+// Items are told apart by their GUID rather than their date, so an item
+// that shows up with a date older than the others is still new. Unseen
+// items dated before cutoff are ignored, which keeps a feed that suddenly
+// lists its whole archive from flooding its channels.
+func (b *Bot) refreshFeed(feed *Feed, feedContents *gofeed.Feed, cutoff time.Time, now time.Time) error {
+	logger := slog.With(
+		slog.String("request_url", feed.Link),
+		slog.Int64("feed_id", feed.ID),
+	)
+
+	// Feeds list their newest items first, so items are taken from the
+	// bottom up. Undated items all get the same first-seen date and keep
+	// that order, which is the best guess at when they were published.
+	var articles []Article
+	for n := len(feedContents.Items) - 1; n >= 0; n-- {
+		articles = append(articles, newArticle(feed.ID, feedContents.Items[n], now))
+	}
 
-	cacheControl := r.Header.Values("Cache-Control")
-	for _, value := range cacheControl {
-		parts := strings.Split(value, "=")
-		if len(parts) != 2 {
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Published.Before(articles[j].Published)
+	})
+
+	hashes, err := b.articles.Hashes(feed.ID)
+	if err != nil {
+		return fmt.Errorf("load article hashes: %w", err)
+	}
+
+	for _, art := range articles {
+		u, err := url.Parse(art.Link)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Warn("parse URL")
 			continue
 		}
+		art.Link = u.String()
 
-		k, v := parts[0], parts[1]
-		switch strings.ToLower(k) {
-		case "max-age":
-			maxAge = parseInt64OrDefault(v, defaultCache)
-		case "s-maxage":
-			sMaxAge = parseInt64OrDefault(v, defaultCache)
-		default:
-			// no-op
+		// Podcast episodes without artwork of their own show the
+		// podcast's.
+		if art.ImageURL == "" && art.MediaURL != "" && feedContents.Image != nil {
+			art.ImageURL = feedContents.Image.URL
+		}
+
+		guid := art.GUID
+		if guid == "" {
+			guid = normalizeURL(art.Link)
+		}
+
+		// Items that were already seen may have been changed since.
+		if hash, ok := hashes[guid]; ok {
+			if hash != art.ContentHash {
+				b.updateArticle(logger, art)
+			}
+			continue
+		}
+
+		if art.Published.Before(cutoff.UTC()) {
+			continue
+		}
+
+		article, err := b.articles.Create(art)
+		if errors.Is(err, ErrAlreadyExists) {
+			// Another crawl added it after the hashes were loaded.
+			b.updateArticle(logger, art)
+			continue
+		}
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("add new article")
+			continue
 		}
+
+		logger.With(slog.Int64("article_id", article.ID), slog.String("date_source", string(article.DateSource))).Info("Added new article")
+	}
+
+	return nil
+}
+
+// updateArticle stores what the feed now says about an article that was
+// already added.
+func (b *Bot) updateArticle(logger *slog.Logger, art Article) {
+	changed, err := b.articles.Update(art)
+	switch {
+	case err != nil:
+		logger.With(slog.String("guid", art.GUID), slog.Any("err", err)).Error("update article")
+	case changed:
+		logger.With(slog.String("guid", art.GUID)).Info("Article was updated")
+	}
+}
+
+const internalErrorMessage = `🪿 ashamed honk. I ran into an issue processing this request. I have failed you. This might be a bug.`
+
+func (b *Bot) respondInternalError(s *discordgo.Session, i *discordgo.Interaction) {
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+	)
+	err := b.respondToInteraction(s, i, internalErrorMessage)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("respond with internal error")
 	}
+}
+
+func (b *Bot) respondToInteraction(s *discordgo.Session, i *discordgo.Interaction, message string) error {
+	return s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+func (b *Bot) respondToInteractionWithEmbed(s *discordgo.Session, i *discordgo.Interaction, message string, embed *discordgo.MessageEmbed) error {
+	return s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Embeds:  []*discordgo.MessageEmbed{embed},
+		},
+	})
+}
 
-	notUntilSeconds := defaultCache
-	if maxAge > 0 {
-		notUntilSeconds = min(notUntilSeconds, maxAge)
-	} else if sMaxAge > 0 {
-		notUntilSeconds = min(notUntilSeconds, sMaxAge)
+// resolvedChannel returns the channel chosen for a channel option. Its
+// type comes from the channels Discord resolves with the interaction,
+// since threads goose hasn't seen yet are missing from the state cache.
+func resolvedChannel(s *discordgo.Session, data discordgo.ApplicationCommandInteractionData, opt *discordgo.ApplicationCommandInteractionDataOption) *discordgo.Channel {
+	if id, ok := opt.Value.(string); ok && data.Resolved != nil {
+		if channel, ok := data.Resolved.Channels[id]; ok && channel != nil {
+			return channel
+		}
 	}
+	return opt.ChannelValue(s)
+}
 
-	notUntil := now.Add(time.Duration(notUntilSeconds) * time.Second)
+func optionsToMap(opts []*discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(opts))
+	for _, opt := range opts {
+		options[opt.Name] = opt
+	}
+	return options
+}
 
-	return notUntil
+// setConditionalHeaders adds the validators from the feed's previous
+// fetch to req so that an unchanged feed can be answered with a
+// 304 Not Modified instead of the full body.
+func setConditionalHeaders(req *http.Request, feed *Feed) {
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
 }
@@ -0,0 +1,1146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mmcdole/gofeed"
+)
+
+// testFeedServer serves an RSS feed whose items can be changed while a
+// test runs.
+type testFeedServer struct {
+	mu      sync.Mutex
+	items   []string
+	status  int
+	version int
+}
+
+func (s *testFeedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d"`, s.version)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title>`)
+	for _, item := range s.items {
+		fmt.Fprint(w, item)
+	}
+	fmt.Fprint(w, `</channel></rss>`)
+}
+
+func (s *testFeedServer) add(title string, published time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = append(s.items, fmt.Sprintf(`<item><title>%s</title><link>http://example.com/%s</link><pubDate>%s</pubDate></item>`,
+		title, url.PathEscape(title), published.Format(time.RFC1123Z)))
+	s.version++
+}
+
+// retitle changes the title of an item without changing its link.
+func (s *testFeedServer) retitle(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, item := range s.items {
+		s.items[n] = strings.Replace(item, "<title>"+from+"</title>", "<title>"+to+"</title>", 1)
+	}
+	s.version++
+}
+
+// addUndated puts an item without a date at the top of the feed, where
+// feeds list their newest items.
+func (s *testFeedServer) addUndated(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := fmt.Sprintf(`<item><title>%s</title><link>http://example.com/%s</link></item>`, title, url.PathEscape(title))
+	s.items = append([]string{item}, s.items...)
+	s.version++
+}
+
+func (s *testFeedServer) fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+func newTestBot(stores Stores, client *http.Client) *Bot {
+	return &Bot{
+		articles:      stores.Articles,
+		feeds:         stores.Feeds,
+		collections:   stores.Collections,
+		subscriptions: stores.Subscriptions,
+		filters:       stores.Filters,
+		deliveries:    stores.Deliveries,
+		httpClient:    client,
+		cachePolicy: &CachePolicy{
+			Default: defaultCache,
+			Floor:   defaultCacheFloor,
+			Ceiling: defaultCacheCeiling,
+		},
+		crawler:              &Crawler{Client: client},
+		disableAfterFailures: defaultDisableAfterFailures,
+		lookback:             defaultLookback,
+	}
+}
+
+// makeReady lets the next RefreshFeeds crawl the feed regardless of its
+// caching headers.
+func makeReady(t *testing.T, feeds FeedStore, link string) {
+	t.Helper()
+
+	feed, err := feeds.GetByLink(link)
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+
+	feed.NotUntil = time.Time{}
+	err = feeds.Update(feed)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+func TestBotSubscribeAndRefresh(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.add("Old news", time.Now().Add(-time.Hour))
+
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	feed, err := stores.Feeds.GetByLink(link.String())
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+	if feed.Title != "Honk Times" || feed.ETag != `"1"` {
+		t.Fatalf("want Title=%q ETag=%q, got Title=%q ETag=%q", "Honk Times", `"1"`, feed.Title, feed.ETag)
+	}
+
+	// Items from before the subscription aren't announced.
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("want no notifications after subscribing, got [%+v]", claimed)
+	}
+
+	// Nothing changed, so the server replies 304 Not Modified.
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	feedServer.add("Breaking honks", time.Now().Add(time.Hour))
+
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	claimed, err = stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Article.Title != "Breaking honks" || claimed[0].CollectionName != "honk" || claimed[0].FeedTitle != "Honk Times" {
+		t.Fatalf("want one notification for the new item, got [%+v]", claimed)
+	}
+
+	feed, err = stores.Feeds.GetByLink(link.String())
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+	if feed.ETag != `"2"` || feed.ConsecutiveFailures != 0 || feed.NotUntilReason != cacheReasonDefault {
+		t.Fatalf("want ETag=%q and a healthy feed, got [%+v]", `"2"`, *feed)
+	}
+}
+
+func TestBotLostLeadership(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	feedServer.add("Breaking honks", time.Now().Add(time.Hour))
+
+	var leader atomic.Bool
+	b.isLeader = leader.Load
+
+	// The lease was lost after the crawl was scheduled.
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	feed, err := stores.Feeds.GetByLink(link.String())
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+	if feed.ETag != `"0"` {
+		t.Fatalf("want the feed left alone by a former leader, got ETag=%q", feed.ETag)
+	}
+
+	leader.Store(true)
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	// A former leader leaves the announcements to the new one. There's
+	// no Discord session, so sending anything would fail the test.
+	leader.Store(false)
+	err = b.Update(ctx)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Article.Title != "Breaking honks" {
+		t.Fatalf("want the new item left for the leader to announce, got [%+v]", claimed)
+	}
+}
+
+func TestBotRefreshUndated(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.addUndated("Old news")
+
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	feed, err := stores.Feeds.GetByLink(link.String())
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+
+	latest, err := stores.Articles.Latest(feed.ID)
+	if err != nil || latest.Title != "Old news" || latest.DateSource != DateSourceFirstSeen {
+		t.Fatalf("want the undated item dated by when it was first seen, got [%+v] err=%v", latest, err)
+	}
+
+	feedServer.addUndated("Second honk")
+	feedServer.addUndated("Third honk")
+
+	// Undated items are new for as long as they haven't been seen, and
+	// are announced in the order they're listed from the bottom up.
+	for n := 0; n < 2; n++ {
+		makeReady(t, stores.Feeds, link.String())
+		err = b.RefreshFeeds(ctx)
+		if err != nil {
+			t.Fatalf("RefreshFeeds: %v", err)
+		}
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].Article.Title != "Second honk" || claimed[1].Article.Title != "Third honk" {
+		t.Fatalf("want notifications for the new undated items in order, got [%+v]", claimed)
+	}
+}
+
+func TestBotRefreshUpdated(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.add("Old news", time.Now().Add(-time.Hour))
+
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	for _, c := range []Collection{
+		{ServerID: "server1", Name: "edits", ChannelID: "channel1", UpdateMode: UpdateModeEdit},
+		{ServerID: "server1", Name: "quiet", ChannelID: "channel2"},
+	} {
+		err = b.subscribe(link, c)
+		if err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+	}
+
+	feedServer.add("Breaking honks", time.Now().Add(time.Hour))
+
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("want a notification for each collection, got [%+v]", claimed)
+	}
+
+	for _, n := range claimed {
+		err = stores.Deliveries.Sent(n.DeliveryID, n.ChannelID, "message-"+n.CollectionName)
+		if err != nil {
+			t.Fatalf("Sent: %v", err)
+		}
+	}
+
+	feedServer.retitle("Breaking honks", "Breaking honks, corrected")
+
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	claimed, err = stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 1 || !claimed[0].Update || claimed[0].MessageID != "message-edits" || claimed[0].Article.Title != "Breaking honks, corrected" {
+		t.Fatalf("want one update of the announcement in the collection following updates, got [%+v]", claimed)
+	}
+}
+
+// countingArticles counts the updates goose asks an article store for.
+type countingArticles struct {
+	ArticleStore
+	updates int
+}
+
+func (c *countingArticles) Update(article Article) (bool, error) {
+	c.updates++
+	return c.ArticleStore.Update(article)
+}
+
+func TestBotRefreshUnchanged(t *testing.T) {
+	stores := NewMemoryStores()
+	articles := &countingArticles{ArticleStore: stores.Articles}
+
+	b := newTestBot(stores, http.DefaultClient)
+	b.articles = articles
+
+	link, err := url.Parse("http://example.com/feed")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	parse := func(title string) *gofeed.Feed {
+		t.Helper()
+
+		contents, err := gofeed.NewParser().ParseString(`<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title>
+			<item><title>` + title + `</title><guid>honk-2</guid><pubDate>Wed, 09 Aug 2023 12:00:00 +0000</pubDate></item>
+			<item><title>Goose spotted</title><guid>honk-1</guid><pubDate>Tue, 08 Aug 2023 12:00:00 +0000</pubDate></item>
+			</channel></rss>`)
+		if err != nil {
+			t.Fatalf("ParseString: %v", err)
+		}
+		return contents
+	}
+
+	for _, title := range []string{"Goose gone", "Goose gone"} {
+		err = b.refreshFeed(feed, parse(title), time.Time{}, time.Now())
+		if err != nil {
+			t.Fatalf("refreshFeed: %v", err)
+		}
+	}
+
+	if articles.updates != 0 {
+		t.Fatalf("want no updates of unchanged items, got %d", articles.updates)
+	}
+
+	err = b.refreshFeed(feed, parse("Goose back"), time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("refreshFeed: %v", err)
+	}
+
+	if articles.updates != 1 {
+		t.Fatalf("want only the changed item updated, got %d updates", articles.updates)
+	}
+}
+
+func TestBotRefreshTrackingParams(t *testing.T) {
+	stores := NewMemoryStores()
+	b := newTestBot(stores, http.DefaultClient)
+
+	link, err := url.Parse("http://example.com/feed")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	c, err := stores.Collections.Create(Collection{ServerID: "server1", Name: "edits", ChannelID: "channel1", UpdateMode: UpdateModeEdit})
+	if err != nil {
+		t.Fatalf("Create collection: %v", err)
+	}
+
+	_, err = stores.Subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID})
+	if err != nil {
+		t.Fatalf("Create subscription: %v", err)
+	}
+
+	// The item has no GUID, and every crawl links to it with a different
+	// campaign.
+	for _, campaign := range []string{"monday", "tuesday"} {
+		contents, err := gofeed.NewParser().ParseString(`<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title>
+			<item><title>Goose spotted</title><link>http://example.com/1?utm_campaign=` + campaign + `</link><pubDate>Wed, 09 Aug 2023 12:00:00 +0000</pubDate></item>
+			</channel></rss>`)
+		if err != nil {
+			t.Fatalf("ParseString: %v", err)
+		}
+
+		err = b.refreshFeed(feed, contents, time.Time{}, time.Now())
+		if err != nil {
+			t.Fatalf("refreshFeed: %v", err)
+		}
+
+		claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+		if err != nil {
+			t.Fatalf("Claim: %v", err)
+		}
+
+		for _, n := range claimed {
+			if n.Update {
+				t.Fatalf("want no update when only tracking parameters change, got [%+v]", n)
+			}
+
+			err = stores.Deliveries.Sent(n.DeliveryID, n.ChannelID, "message-1")
+			if err != nil {
+				t.Fatalf("Sent: %v", err)
+			}
+		}
+	}
+}
+
+// TestBotRefreshLateItems crawls feeds in which items show up with dates
+// older than the ones already announced, the way aggregators list posts
+// from a newly added blog and blogs publish posts that were scheduled or
+// drafted earlier.
+func TestBotRefreshLateItems(t *testing.T) {
+	now := time.Date(2023, 8, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		fixtures   []string
+		wantTitles []string
+	}{
+		{
+			name:       "aggregator adds a blog",
+			fixtures:   []string{"testdata/planet-1.xml", "testdata/planet-2.xml"},
+			wantTitles: []string{"Dave Eider: A goose in the data center"},
+		},
+		{
+			name:       "scheduled post",
+			fixtures:   []string{"testdata/scheduled-1.xml", "testdata/scheduled-2.xml"},
+			wantTitles: []string{"Migrating the pond to SQLite"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stores := NewMemoryStores()
+
+			var mu sync.Mutex
+			fixture := tt.fixtures[0]
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				http.ServeFile(w, r, fixture)
+			}))
+			defer srv.Close()
+
+			b := newTestBot(stores, srv.Client())
+
+			link, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatalf("url.Parse: %v", err)
+			}
+
+			err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+			if err != nil {
+				t.Fatalf("subscribe: %v", err)
+			}
+
+			feed, err := stores.Feeds.GetByLink(link.String())
+			if err != nil {
+				t.Fatalf("GetByLink: %v", err)
+			}
+
+			// Every crawl sees the same items again, which are only
+			// announced the first time.
+			for _, f := range tt.fixtures[1:] {
+				mu.Lock()
+				fixture = f
+				mu.Unlock()
+
+				for n := 0; n < 2; n++ {
+					feed.ETag, feed.LastModified = "", ""
+					err = b.crawl(ctx, feed, now)
+					if err != nil {
+						t.Fatalf("crawl %s: %v", f, err)
+					}
+				}
+			}
+
+			claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+			if err != nil {
+				t.Fatalf("Claim: %v", err)
+			}
+
+			var titles []string
+			for _, n := range claimed {
+				titles = append(titles, n.Article.Title)
+			}
+
+			if !reflect.DeepEqual(tt.wantTitles, titles) {
+				t.Fatalf("want announcements %q, got %q", tt.wantTitles, titles)
+			}
+		})
+	}
+}
+
+func TestBotRefreshLookback(t *testing.T) {
+	now := time.Date(2023, 8, 10, 12, 0, 0, 0, time.UTC)
+
+	contents, err := gofeed.NewParser().ParseString(`<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title>
+		<item><title>Yesterday</title><guid>honk-3</guid><pubDate>Wed, 09 Aug 2023 12:00:00 +0000</pubDate></item>
+		<item><title>Last month</title><guid>honk-2</guid><pubDate>Mon, 10 Jul 2023 12:00:00 +0000</pubDate></item>
+		<item><title>Last year</title><guid>honk-1</guid><pubDate>Wed, 10 Aug 2022 12:00:00 +0000</pubDate></item>
+		</channel></rss>`)
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		lookback   time.Duration
+		wantTitles []string
+	}{
+		{name: "default", lookback: defaultLookback, wantTitles: []string{"Yesterday"}},
+		{name: "longer", lookback: 60 * 24 * time.Hour, wantTitles: []string{"Last month", "Yesterday"}},
+		{name: "unlimited", lookback: 0, wantTitles: []string{"Last year", "Last month", "Yesterday"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := NewMemoryStores()
+
+			b := newTestBot(stores, http.DefaultClient)
+			b.lookback = tt.lookback
+
+			link, err := url.Parse("http://example.com/feed")
+			if err != nil {
+				t.Fatalf("url.Parse: %v", err)
+			}
+
+			feed, err := stores.Feeds.Create(link, now)
+			if err != nil {
+				t.Fatalf("Create feed: %v", err)
+			}
+
+			c, err := stores.Collections.Create(Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+			if err != nil {
+				t.Fatalf("Create collection: %v", err)
+			}
+
+			_, err = stores.Subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID, LastPubDate: now})
+			if err != nil {
+				t.Fatalf("Create subscription: %v", err)
+			}
+
+			err = b.refreshFeed(feed, contents, b.cutoff(now), now)
+			if err != nil {
+				t.Fatalf("refreshFeed: %v", err)
+			}
+
+			claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+			if err != nil {
+				t.Fatalf("Claim: %v", err)
+			}
+
+			var titles []string
+			for _, n := range claimed {
+				titles = append(titles, n.Article.Title)
+			}
+
+			if !reflect.DeepEqual(tt.wantTitles, titles) {
+				t.Fatalf("want announcements %q, got %q", tt.wantTitles, titles)
+			}
+		})
+	}
+}
+
+func TestBotRefreshPodcast(t *testing.T) {
+	stores := NewMemoryStores()
+	b := newTestBot(stores, http.DefaultClient)
+
+	f, err := os.Open("testdata/podcast.xml")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	contents, err := gofeed.NewParser().Parse(f)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	link, err := url.Parse("https://podcast.example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	c, err := stores.Collections.Create(Collection{ServerID: "server1", Name: "podcasts", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("Create collection: %v", err)
+	}
+
+	_, err = stores.Subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID})
+	if err != nil {
+		t.Fatalf("Create subscription: %v", err)
+	}
+
+	err = b.refreshFeed(feed, contents, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("refreshFeed: %v", err)
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	// Episodes show their own artwork, or else the podcast's.
+	images := make(map[string]string)
+	for _, n := range claimed {
+		images[n.Article.Title] = n.Article.ImageURL
+	}
+
+	want := map[string]string{
+		"Trailer":          "https://podcast.example.com/artwork.jpg",
+		"Migration season": "https://podcast.example.com/episodes/42.jpg",
+	}
+	if !reflect.DeepEqual(want, images) {
+		t.Fatalf("want artwork %q, got %q", want, images)
+	}
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		feed            Feed
+		ifNoneMatch     string
+		ifModifiedSince string
+	}{
+		{
+			name: "no validators",
+		},
+		{
+			name:        "etag",
+			feed:        Feed{ETag: `"honk"`},
+			ifNoneMatch: `"honk"`,
+		},
+		{
+			name:            "last modified",
+			feed:            Feed{LastModified: "Wed, 09 Aug 2023 12:00:00 GMT"},
+			ifModifiedSince: "Wed, 09 Aug 2023 12:00:00 GMT",
+		},
+		{
+			name:            "both",
+			feed:            Feed{ETag: `W/"honk"`, LastModified: "Wed, 09 Aug 2023 12:00:00 GMT"},
+			ifNoneMatch:     `W/"honk"`,
+			ifModifiedSince: "Wed, 09 Aug 2023 12:00:00 GMT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://example.com/feed.xml", nil)
+
+			setConditionalHeaders(req, &tt.feed)
+
+			if got := req.Header.Get("If-None-Match"); got != tt.ifNoneMatch {
+				t.Errorf("want If-None-Match %q, got %q", tt.ifNoneMatch, got)
+			}
+			if got := req.Header.Get("If-Modified-Since"); got != tt.ifModifiedSince {
+				t.Errorf("want If-Modified-Since %q, got %q", tt.ifModifiedSince, got)
+			}
+		})
+	}
+}
+
+func TestBotCrawlNotModified(t *testing.T) {
+	const (
+		etag         = `"honk"`
+		lastModified = "Wed, 09 Aug 2023 12:00:00 GMT"
+	)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title>`+
+			`<item><title>Honk</title><link>http://example.com/honk</link><pubDate>Wed, 09 Aug 2023 11:00:00 GMT</pubDate></item>`+
+			`</channel></rss>`)
+	}))
+	defer srv.Close()
+
+	stores := NewMemoryStores()
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	now := time.Date(2023, 8, 9, 12, 0, 0, 0, time.UTC)
+
+	err = b.crawl(context.Background(), feed, now)
+	if err != nil {
+		t.Fatalf("crawl: %v", err)
+	}
+	if feed.ETag != etag || feed.LastModified != lastModified {
+		t.Fatalf("want validators %q and %q stored, got %q and %q", etag, lastModified, feed.ETag, feed.LastModified)
+	}
+
+	latest, err := stores.Articles.Latest(feed.ID)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+
+	// The feed hasn't changed, so the server answers with an empty 304
+	// that must neither fail the crawl nor lose the validators.
+	now = now.Add(2 * time.Hour)
+	feed.NotUntil = time.Time{}
+
+	err = b.crawl(context.Background(), feed, now)
+	if err != nil {
+		t.Fatalf("crawl not modified: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("want 2 requests, got %d", n)
+	}
+	if feed.ETag != etag || feed.LastModified != lastModified {
+		t.Fatalf("want validators %q and %q kept, got %q and %q", etag, lastModified, feed.ETag, feed.LastModified)
+	}
+	if want := now.Add(time.Hour); !feed.NotUntil.Equal(want) {
+		t.Fatalf("want next crawl at %v from the 304's caching headers, got %v", want, feed.NotUntil)
+	}
+
+	again, err := stores.Articles.Latest(feed.ID)
+	if err != nil || again.ID != latest.ID {
+		t.Fatalf("want no new articles, got [%+v] err=%v", again, err)
+	}
+}
+
+func TestBotCrawlTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title><description>`)
+		fmt.Fprint(w, strings.Repeat("honk ", feedSizeMax/5))
+		fmt.Fprint(w, `</description></channel></rss>`)
+	}))
+	defer srv.Close()
+
+	stores := NewMemoryStores()
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	err = b.crawl(context.Background(), feed, time.Now())
+	if !errors.Is(err, ErrFeedTooLarge) {
+		t.Fatalf("want err=%v, got err=%v", ErrFeedTooLarge, err)
+	}
+}
+
+func TestResolvedChannel(t *testing.T) {
+	data := discordgo.ApplicationCommandInteractionData{
+		Resolved: &discordgo.ApplicationCommandInteractionDataResolved{
+			Channels: map[string]*discordgo.Channel{
+				"123": {ID: "123", Type: discordgo.ChannelTypeGuildPublicThread},
+			},
+		},
+	}
+
+	opt := &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  optionChannel,
+		Type:  discordgo.ApplicationCommandOptionChannel,
+		Value: "123",
+	}
+
+	// There's no session, so nothing could be looked up in its cache.
+	got := resolvedChannel(nil, data, opt)
+	if got.ID != "123" || got.Type != discordgo.ChannelTypeGuildPublicThread {
+		t.Fatalf("want the resolved thread, got [%+v]", got)
+	}
+
+	opt.Value = "456"
+	got = resolvedChannel(nil, data, opt)
+	if got.ID != "456" {
+		t.Fatalf("want a channel with ID 456, got [%+v]", got)
+	}
+}
+
+// newCommand builds a slash command interaction in server1 with the
+// given options, resolving the channels among them as text channels.
+func newCommand(name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	data := discordgo.ApplicationCommandInteractionData{
+		Name:     name,
+		Options:  opts,
+		Resolved: &discordgo.ApplicationCommandInteractionDataResolved{Channels: map[string]*discordgo.Channel{}},
+	}
+
+	for _, opt := range opts {
+		if opt.Type == discordgo.ApplicationCommandOptionChannel {
+			id := opt.Value.(string)
+			data.Resolved.Channels[id] = &discordgo.Channel{ID: id, Type: discordgo.ChannelTypeGuildText}
+		}
+	}
+
+	return &discordgo.Interaction{
+		ID:      "interaction1",
+		Token:   "token",
+		Type:    discordgo.InteractionApplicationCommand,
+		GuildID: "server1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "user1"}},
+		Data:    data,
+	}
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func channelOption(id string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: optionChannel, Type: discordgo.ApplicationCommandOptionChannel, Value: id}
+}
+
+func TestBotEditNameTaken(t *testing.T) {
+	stores := NewMemoryStores()
+	d := &discordRecorder{}
+
+	b := newTestBot(stores, http.DefaultClient)
+	b.shards = newRecordingBot(t, d).shards
+
+	for _, c := range []Collection{
+		{ServerID: "server1", Name: "honk", ChannelID: "channel1"},
+		{ServerID: "server1", Name: "taken", ChannelID: "channel2"},
+	} {
+		_, err := stores.Collections.Create(c)
+		if err != nil {
+			t.Fatalf("Create collection: %v", err)
+		}
+	}
+
+	b.Edit(b.shards.Any(), newCommand("edit",
+		stringOption(optionCollectionName, "honk"),
+		channelOption("channel3"),
+		stringOption(optionName, "taken"),
+	))
+
+	if len(d.bodies) != 1 || !strings.Contains(d.bodies[0], "already a collection called") {
+		t.Fatalf("want the name refused, got %q", d.bodies)
+	}
+
+	// Nothing about the collection changes when part of the edit fails.
+	c, err := stores.Collections.GetByName("server1", "honk")
+	if err != nil {
+		t.Fatalf("GetByName: %v", err)
+	}
+	if c.ChannelID != "channel1" {
+		t.Fatalf("want the collection left in channel1, got %q", c.ChannelID)
+	}
+}
+
+func TestBotSubscribeSameChannel(t *testing.T) {
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	d := &discordRecorder{}
+	b := newTestBot(stores, srv.Client())
+	b.shards = newRecordingBot(t, d).shards
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "one", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// Another collection announcing to the same channel would post every
+	// item twice.
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "two", ChannelID: "channel1"})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("want err=%v, got err=%v when subscribing another collection in the same channel", ErrAlreadyExists, err)
+	}
+
+	_, err = stores.Collections.GetByName("server1", "two")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want no collection left behind by a failed subscribe, got err=%v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "two", ChannelID: "channel2"})
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when subscribing in another channel", err)
+	}
+
+	// Nor can the other collection be moved into the first one's channel.
+	b.Edit(b.shards.Any(), newCommand("edit",
+		stringOption(optionCollectionName, "two"),
+		channelOption("channel1"),
+	))
+
+	if len(d.bodies) != 1 || !strings.Contains(d.bodies[0], "already gets new items from") {
+		t.Fatalf("want the move refused, got %q", d.bodies)
+	}
+
+	c, err := stores.Collections.GetByName("server1", "two")
+	if err != nil {
+		t.Fatalf("GetByName: %v", err)
+	}
+	if c.ChannelID != "channel2" {
+		t.Fatalf("want the collection left in channel2, got %q", c.ChannelID)
+	}
+}
+
+func TestBotRefreshFailure(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.add("Old news", time.Now().Add(-time.Hour))
+
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	feedServer.fail(http.StatusInternalServerError)
+
+	makeReady(t, stores.Feeds, link.String())
+	err = b.RefreshFeeds(ctx)
+	if err != nil {
+		t.Fatalf("RefreshFeeds: %v", err)
+	}
+
+	feed, err := stores.Feeds.GetByLink(link.String())
+	if err != nil {
+		t.Fatalf("GetByLink: %v", err)
+	}
+	if feed.ConsecutiveFailures != 1 || feed.LastError != "internal server error" {
+		t.Fatalf("want one recorded failure, got [%+v]", *feed)
+	}
+}
+
+func TestBotCollection(t *testing.T) {
+	stores := NewMemoryStores()
+
+	items := []struct {
+		title     string
+		published time.Time
+	}{
+		{"Older honk", time.Now().Add(-2 * time.Hour)},
+		{"Newer honk", time.Now().Add(-time.Hour)},
+	}
+
+	var links []*url.URL
+	for _, item := range items {
+		feedServer := &testFeedServer{}
+		feedServer.add(item.title, item.published)
+
+		srv := httptest.NewServer(feedServer)
+		defer srv.Close()
+
+		link, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatalf("url.Parse: %v", err)
+		}
+		links = append(links, link)
+	}
+
+	b := newTestBot(stores, http.DefaultClient)
+
+	for _, link := range links {
+		err := b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+		if err != nil {
+			t.Fatalf("subscribe [%s]: %v", link, err)
+		}
+	}
+
+	err := b.subscribe(links[0], Collection{ServerID: "server1", Name: "honk", ChannelID: "channel2"})
+	if !errors.Is(err, ErrCollectionNameTaken) {
+		t.Fatalf("want err=%v, got err=%v when subscribing to a collection in another channel", ErrCollectionNameTaken, err)
+	}
+
+	collection, err := stores.Collections.GetByName("server1", "honk")
+	if err != nil {
+		t.Fatalf("GetByName: %v", err)
+	}
+
+	subs, err := stores.Subscriptions.ListByCollection(collection.ID)
+	if err != nil || len(subs) != 2 {
+		t.Fatalf("want two feeds in the collection, got [%+v] err=%v", subs, err)
+	}
+
+	// /test shows the latest item across every feed in the collection.
+	latest, _, err := b.test("server1", "honk")
+	if err != nil {
+		t.Fatalf("test: %v", err)
+	}
+	if latest.Title != "Newer honk" {
+		t.Fatalf("want latest item %q, got %q", "Newer honk", latest.Title)
+	}
+
+	removed, err := b.unsubscribe("server1", "honk", links[0])
+	if err != nil || removed {
+		t.Fatalf("want removed=false err=<nil>, got removed=%v err=%v when removing one feed", removed, err)
+	}
+
+	removed, err = b.unsubscribe("server1", "honk", links[0])
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want err=%v, got err=%v when removing a feed that isn't in the collection", ErrNotFound, err)
+	}
+
+	// Removing the last feed removes the collection.
+	removed, err = b.unsubscribe("server1", "honk", links[1])
+	if err != nil || !removed {
+		t.Fatalf("want removed=true err=<nil>, got removed=%v err=%v when removing the last feed", removed, err)
+	}
+
+	_, err = stores.Collections.GetByName("server1", "honk")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want err=%v, got err=%v after removing the last feed", ErrNotFound, err)
+	}
+}
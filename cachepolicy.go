@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCache        = 6 * time.Hour
+	defaultCacheFloor   = 10 * time.Minute
+	defaultCacheCeiling = 24 * time.Hour
+
+	// maxDeltaSeconds is the value RFC 9111 suggests treating as
+	// "infinity" for delta-seconds.
+	maxDeltaSeconds = 2147483648
+)
+
+// Reasons recorded on a feed to explain which rule decided its
+// NotUntil time.
+const (
+	cacheReasonDefault        = "default"
+	cacheReasonRetryAfter     = "retry-after"
+	cacheReasonNoStore        = "no-store"
+	cacheReasonNoCache        = "no-cache"
+	cacheReasonMaxAge         = "max-age"
+	cacheReasonSMaxAge        = "s-maxage"
+	cacheReasonExpires        = "expires"
+	cacheReasonMustRevalidate = "must-revalidate"
+	cacheReasonFailureBackoff = "failure backoff"
+)
+
+// CachePolicy decides how long to wait before crawling a feed again
+// based on the caching headers in its most recent response.
+type CachePolicy struct {
+	// Default is used when the response carries no usable freshness
+	// information.
+	Default time.Duration
+
+	// Floor and Ceiling bound every computed lifetime so that a feed
+	// is neither hammered nor forgotten. A zero Ceiling means unbounded.
+	Floor   time.Duration
+	Ceiling time.Duration
+}
+
+// NotUntil returns the earliest time the feed behind r should be fetched
+// again, along with the name of the rule that decided it.
+func (p *CachePolicy) NotUntil(r *http.Response, now time.Time) (time.Time, string) {
+	lifetime, reason := p.lifetime(r, now)
+
+	switch {
+	case lifetime < p.Floor:
+		lifetime = p.Floor
+		reason += " (floor)"
+	case p.Ceiling > 0 && lifetime > p.Ceiling:
+		lifetime = p.Ceiling
+		reason += " (ceiling)"
+	}
+
+	return now.Add(lifetime), reason
+}
+
+func (p *CachePolicy) lifetime(r *http.Response, now time.Time) (time.Duration, string) {
+	if r.StatusCode == http.StatusTooManyRequests || r.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(r.Header.Get("Retry-After"), now); ok {
+			return wait, cacheReasonRetryAfter
+		}
+	}
+
+	cc := parseCacheControl(r.Header.Values("Cache-Control"))
+
+	switch {
+	case cc.noStore:
+		return 0, cacheReasonNoStore
+	case cc.noCache:
+		return 0, cacheReasonNoCache
+	}
+
+	age, _ := parseDeltaSeconds(r.Header.Get("Age"))
+
+	switch {
+	case cc.hasMaxAge:
+		return cc.maxAge - age, cacheReasonMaxAge
+	case cc.hasSMaxAge:
+		return cc.sMaxAge - age, cacheReasonSMaxAge
+	}
+
+	if expires := r.Header.Get("Expires"); expires != "" {
+		// An Expires value that can't be parsed means the response
+		// is already stale.
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, cacheReasonExpires
+		}
+
+		date := now
+		if d, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+			date = d
+		}
+
+		return exp.Sub(date) - age, cacheReasonExpires
+	}
+
+	// Without an explicit lifetime, must-revalidate forbids guessing one.
+	if cc.mustRevalidate {
+		return 0, cacheReasonMustRevalidate
+	}
+
+	def := p.Default
+	if def == 0 {
+		def = defaultCache
+	}
+
+	return def, cacheReasonDefault
+}
+
+type cacheDirectives struct {
+	maxAge     time.Duration
+	hasMaxAge  bool
+	sMaxAge    time.Duration
+	hasSMaxAge bool
+
+	noCache        bool
+	noStore        bool
+	mustRevalidate bool
+}
+
+func parseCacheControl(values []string) cacheDirectives {
+	var cc cacheDirectives
+
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			arg = strings.Trim(strings.TrimSpace(arg), `"`)
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "max-age":
+				if d, ok := parseDeltaSeconds(arg); ok {
+					cc.maxAge, cc.hasMaxAge = d, true
+				}
+			case "s-maxage":
+				if d, ok := parseDeltaSeconds(arg); ok {
+					cc.sMaxAge, cc.hasSMaxAge = d, true
+				}
+			case "no-cache":
+				cc.noCache = true
+			case "no-store":
+				cc.noStore = true
+			case "must-revalidate":
+				cc.mustRevalidate = true
+			default:
+				// no-op
+			}
+		}
+	}
+
+	return cc
+}
+
+func parseDeltaSeconds(s string) (time.Duration, bool) {
+	secs, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	if secs > maxDeltaSeconds {
+		secs = maxDeltaSeconds
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+func parseRetryAfter(s string, now time.Time) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	if d, ok := parseDeltaSeconds(s); ok {
+		return d, true
+	}
+
+	if t, err := http.ParseTime(s); err == nil {
+		return t.Sub(now), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCachePolicyNotUntil(t *testing.T) {
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	policy := &CachePolicy{
+		Default: defaultCache,
+		Floor:   10 * time.Minute,
+		Ceiling: 24 * time.Hour,
+	}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		header     http.Header
+		wantWait   time.Duration
+		wantReason string
+	}{
+		{
+			name:       "no headers",
+			header:     http.Header{},
+			wantWait:   defaultCache,
+			wantReason: cacheReasonDefault,
+		},
+		{
+			name:       "sole max-age",
+			header:     http.Header{"Cache-Control": {"max-age=3600"}},
+			wantWait:   time.Hour,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "max-age among other directives",
+			header:     http.Header{"Cache-Control": {"public, max-age=600"}},
+			wantWait:   10 * time.Minute,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "quoted max-age",
+			header:     http.Header{"Cache-Control": {`max-age="1200"`}},
+			wantWait:   20 * time.Minute,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "max-age minus age",
+			header:     http.Header{"Cache-Control": {"max-age=3600"}, "Age": {"600"}},
+			wantWait:   50 * time.Minute,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "max-age preferred over s-maxage",
+			header:     http.Header{"Cache-Control": {"s-maxage=7200, max-age=3600"}},
+			wantWait:   time.Hour,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "s-maxage",
+			header:     http.Header{"Cache-Control": {"s-maxage=7200"}},
+			wantWait:   2 * time.Hour,
+			wantReason: cacheReasonSMaxAge,
+		},
+		{
+			name:       "max-age across multiple header values",
+			header:     http.Header{"Cache-Control": {"public", "max-age=1800"}},
+			wantWait:   30 * time.Minute,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name:       "no-cache",
+			header:     http.Header{"Cache-Control": {"no-cache"}},
+			wantWait:   10 * time.Minute,
+			wantReason: cacheReasonNoCache + " (floor)",
+		},
+		{
+			name:       "no-store wins over max-age",
+			header:     http.Header{"Cache-Control": {"max-age=3600, no-store"}},
+			wantWait:   10 * time.Minute,
+			wantReason: cacheReasonNoStore + " (floor)",
+		},
+		{
+			name:       "must-revalidate without lifetime",
+			header:     http.Header{"Cache-Control": {"must-revalidate"}},
+			wantWait:   10 * time.Minute,
+			wantReason: cacheReasonMustRevalidate + " (floor)",
+		},
+		{
+			name:       "must-revalidate with max-age",
+			header:     http.Header{"Cache-Control": {"max-age=3600, must-revalidate"}},
+			wantWait:   time.Hour,
+			wantReason: cacheReasonMaxAge,
+		},
+		{
+			name: "expires relative to date",
+			header: http.Header{
+				"Date":    {"Tue, 01 Aug 2023 11:00:00 GMT"},
+				"Expires": {"Tue, 01 Aug 2023 13:00:00 GMT"},
+			},
+			wantWait:   2 * time.Hour,
+			wantReason: cacheReasonExpires,
+		},
+		{
+			name:       "expires relative to now",
+			header:     http.Header{"Expires": {"Tue, 01 Aug 2023 15:00:00 GMT"}},
+			wantWait:   3 * time.Hour,
+			wantReason: cacheReasonExpires,
+		},
+		{
+			name:       "invalid expires",
+			header:     http.Header{"Expires": {"0"}},
+			wantWait:   10 * time.Minute,
+			wantReason: cacheReasonExpires + " (floor)",
+		},
+		{
+			name:       "max-age above ceiling",
+			header:     http.Header{"Cache-Control": {"max-age=604800"}},
+			wantWait:   24 * time.Hour,
+			wantReason: cacheReasonMaxAge + " (ceiling)",
+		},
+		{
+			name:       "retry-after seconds on 429",
+			statusCode: http.StatusTooManyRequests,
+			header:     http.Header{"Retry-After": {"7200"}, "Cache-Control": {"max-age=60"}},
+			wantWait:   2 * time.Hour,
+			wantReason: cacheReasonRetryAfter,
+		},
+		{
+			name:       "retry-after date on 503",
+			statusCode: http.StatusServiceUnavailable,
+			header:     http.Header{"Retry-After": {"Tue, 01 Aug 2023 13:30:00 GMT"}},
+			wantWait:   90 * time.Minute,
+			wantReason: cacheReasonRetryAfter,
+		},
+		{
+			name:       "retry-after ignored on 200",
+			header:     http.Header{"Retry-After": {"7200"}},
+			wantWait:   defaultCache,
+			wantReason: cacheReasonDefault,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode := tt.statusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+
+			rsp := &http.Response{StatusCode: statusCode, Header: tt.header}
+
+			got, reason := policy.NotUntil(rsp, now)
+			if want := now.Add(tt.wantWait); !got.Equal(want) {
+				t.Errorf("want NotUntil=%v, got NotUntil=%v", want, got)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("want reason=%q, got reason=%q", tt.wantReason, reason)
+			}
+		})
+	}
+}
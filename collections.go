@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Collection groups one or more feeds in a server under a name. Every feed
+// in a collection is announced to the same channel with the same template
+// and filters.
+type Collection struct {
+	ID        int64
+	ServerID  string
+	Name      string
+	ChannelID string
+
+	// Template is the text/template used to phrase announcements for
+	// this collection. The default announcement is used when empty.
+	Template string
+
+	// ChannelType decides how announcements are delivered: forum
+	// channels get a new post per item, and announcements in news
+	// channels are published to following servers if Crosspost is set.
+	ChannelType discordgo.ChannelType
+	Crosspost   bool
+
+	// UpdateMode decides what happens when a feed changes an item that
+	// was already announced.
+	UpdateMode UpdateMode
+}
+
+// UpdateMode is how a collection follows changes to items it already
+// announced.
+type UpdateMode string
+
+const (
+	UpdateModeOff UpdateMode = "off"
+
+	// UpdateModeEdit edits the original announcement to match the item.
+	UpdateModeEdit UpdateMode = "edit"
+
+	// UpdateModeNotice announces that the item was updated.
+	UpdateModeNotice UpdateMode = "notice"
+)
+
+func (m UpdateMode) valid() bool {
+	switch m {
+	case UpdateModeOff, UpdateModeEdit, UpdateModeNotice:
+		return true
+	default:
+		return false
+	}
+}
+
+type Collections struct {
+	db *sql.DB
+}
+
+const collectionColumns = `id, server_id, name, channel_id, template, channel_type, crosspost, update_mode`
+
+func scanCollection(row rowScanner, c *Collection) error {
+	return row.Scan(&c.ID, &c.ServerID, &c.Name, &c.ChannelID, &c.Template, &c.ChannelType, &c.Crosspost, &c.UpdateMode)
+}
+
+// Create adds a collection. It returns ErrCollectionNameTaken if the
+// server already has a collection with that name.
+func (cs *Collections) Create(collection Collection) (*Collection, error) {
+	updateMode := collection.UpdateMode
+	if updateMode == "" {
+		updateMode = UpdateModeOff
+	}
+
+	stmt := `INSERT INTO collections (server_id, name, channel_id, template, channel_type, crosspost, update_mode) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ` + collectionColumns
+	args := []any{collection.ServerID, collection.Name, collection.ChannelID, collection.Template, collection.ChannelType, collection.Crosspost, updateMode}
+
+	var c Collection
+
+	err := scanCollection(cs.db.QueryRow(stmt, args...), &c)
+	if isUniqueViolation(err) {
+		return nil, ErrCollectionNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (cs *Collections) Get(id int64) (*Collection, error) {
+	stmt := `SELECT ` + collectionColumns + ` FROM collections WHERE id = $1`
+	args := []any{id}
+
+	var c Collection
+
+	err := scanCollection(cs.db.QueryRow(stmt, args...), &c)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (cs *Collections) GetByName(serverID, name string) (*Collection, error) {
+	stmt := `SELECT ` + collectionColumns + ` FROM collections WHERE server_id = $1 AND name = $2`
+	args := []any{serverID, name}
+
+	var c Collection
+
+	err := scanCollection(cs.db.QueryRow(stmt, args...), &c)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (cs *Collections) Names(serverID string) ([]string, error) {
+	stmt := `SELECT name FROM collections WHERE server_id = $1`
+	args := []any{serverID}
+
+	rows, err := cs.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (cs *Collections) ListByServer(serverID string) ([]Collection, error) {
+	stmt := `SELECT ` + collectionColumns + ` FROM collections WHERE server_id = $1 ORDER BY name ASC, id ASC`
+	args := []any{serverID}
+
+	rows, err := cs.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		var c Collection
+		err := scanCollection(rows, &c)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+
+	return collections, nil
+}
+
+func (cs *Collections) UpdateTemplate(id int64, template string) error {
+	stmt := `UPDATE collections SET template = $2 WHERE id = $1`
+	args := []any{id, template}
+
+	_, err := cs.db.Exec(stmt, args...)
+
+	return err
+}
+
+// UpdateChannel moves a collection to another channel without touching
+// what its feeds have already announced. It returns ErrNotFound if there
+// is no such collection.
+func (cs *Collections) UpdateChannel(id int64, channelID string, channelType discordgo.ChannelType, crosspost bool) error {
+	stmt := `UPDATE collections SET channel_id = $2, channel_type = $3, crosspost = $4 WHERE id = $1`
+	args := []any{id, channelID, channelType, crosspost}
+
+	res, err := cs.db.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SetUpdateMode changes how a collection follows updates to items it
+// already announced. It returns ErrNotFound if there is no such
+// collection.
+func (cs *Collections) SetUpdateMode(id int64, mode UpdateMode) error {
+	stmt := `UPDATE collections SET update_mode = $2 WHERE id = $1`
+	args := []any{id, mode}
+
+	res, err := cs.db.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Rename changes the name of a collection. It returns
+// ErrCollectionNameTaken if another collection in the server already has
+// that name, and ErrNotFound if there is no such collection.
+func (cs *Collections) Rename(id int64, name string) error {
+	stmt := `UPDATE collections SET name = $2 WHERE id = $1`
+	args := []any{id, name}
+
+	res, err := cs.db.Exec(stmt, args...)
+	if isUniqueViolation(err) {
+		return ErrCollectionNameTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a collection along with its subscriptions, filters and
+// pending announcements.
+func (cs *Collections) Delete(id int64) error {
+	stmt := `DELETE FROM collections WHERE id = $1`
+	args := []any{id}
+
+	_, err := cs.db.Exec(stmt, args...)
+
+	return err
+}
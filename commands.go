@@ -6,10 +6,30 @@ const (
 	commandSubscribe   = "subscribe"
 	commandUnsubscribe = "unsubscribe"
 	commandTest        = "test"
+	commandTemplate    = "template"
+	commandList        = "list"
+	commandFilter      = "filter"
+	commandEdit        = "edit"
+	commandImport      = "import"
+	commandExport      = "export"
+
+	subcommandAdd    = "add"
+	subcommandRemove = "remove"
+	subcommandList   = "list"
 
 	optionChannel        = "channel"
 	optionFeed           = "feed"
 	optionCollectionName = "collection"
+	optionTemplate       = "template"
+	optionCrosspost      = "crosspost"
+	optionName           = "name"
+	optionFile           = "file"
+	optionUpdates        = "updates"
+	optionFilterMode     = "mode"
+	optionFilterField    = "field"
+	optionFilterKind     = "kind"
+	optionFilterPattern  = "pattern"
+	optionFilterID       = "id"
 )
 
 var (
@@ -19,36 +39,54 @@ var (
 	commands = []*discordgo.ApplicationCommand{
 		{
 			Name:                     commandSubscribe,
-			Description:              "Subscribe to an RSS feed",
+			Description:              "Subscribe to an RSS feed, adding it to a new or existing collection",
 			DMPermission:             &dmPermission,
 			DefaultMemberPermissions: &memberPermissions,
 			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        optionFeed,
+					Description: "URL to feed",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:         optionCollectionName,
+					Description:  "Collection to add the feed to, which is created if it doesn't exist",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
 				{
 					Name:        optionChannel,
-					Description: "Channel where new items will be announced to",
+					Description: "Channel where new items will be announced to (required for a new collection)",
 					Type:        discordgo.ApplicationCommandOptionChannel,
 					ChannelTypes: []discordgo.ChannelType{
 						discordgo.ChannelTypeGuildText,
+						discordgo.ChannelTypeGuildNews,
+						discordgo.ChannelTypeGuildNewsThread,
+						discordgo.ChannelTypeGuildPublicThread,
+						discordgo.ChannelTypeGuildPrivateThread,
+						discordgo.ChannelTypeGuildForum,
 					},
-					Required: true,
+					Required: false,
 				},
 				{
-					Name:        optionFeed,
-					Description: "URL to feed",
+					Name:        optionTemplate,
+					Description: "Message template for new items, e.g. {{.Title}} {{.Link}}",
 					Type:        discordgo.ApplicationCommandOptionString,
-					Required:    true,
+					Required:    false,
 				},
 				{
-					Name:        optionCollectionName,
-					Description: "Name for this feed",
-					Type:        discordgo.ApplicationCommandOptionString,
-					Required:    true,
+					Name:        optionCrosspost,
+					Description: "Publish announcements to servers following the channel (announcement channels only)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
 				},
 			},
 		},
 		{
 			Name:                     commandUnsubscribe,
-			Description:              "Unsubscribe from an RSS feed",
+			Description:              "Unsubscribe from a collection, or from one feed in it",
 			DMPermission:             &dmPermission,
 			DefaultMemberPermissions: &memberPermissions,
 			Options: []*discordgo.ApplicationCommandOption{
@@ -59,6 +97,12 @@ var (
 					Required:     true,
 					Autocomplete: true,
 				},
+				{
+					Name:        optionFeed,
+					Description: "URL of a feed to remove from the collection (default: remove the whole collection)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
 			},
 		},
 		{
@@ -76,5 +120,211 @@ var (
 				},
 			},
 		},
+		{
+			Name:                     commandTemplate,
+			Description:              "Change how new items in a collection are announced (leave the template out to reset it)",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:         optionCollectionName,
+					Description:  "Collection to change",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Name:        optionTemplate,
+					Description: "Message template, e.g. New from {{.Collection}}: {{.Title}} {{.Link}}",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:                     commandEdit,
+			Description:              "Move, rename or change how a collection announces items without losing track of what was announced",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:         optionCollectionName,
+					Description:  "Collection to change",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Name:        optionChannel,
+					Description: "Channel where new items will be announced to from now on",
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					ChannelTypes: []discordgo.ChannelType{
+						discordgo.ChannelTypeGuildText,
+						discordgo.ChannelTypeGuildNews,
+						discordgo.ChannelTypeGuildNewsThread,
+						discordgo.ChannelTypeGuildPublicThread,
+						discordgo.ChannelTypeGuildPrivateThread,
+						discordgo.ChannelTypeGuildForum,
+					},
+					Required: false,
+				},
+				{
+					Name:        optionName,
+					Description: "New name for the collection",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        optionCrosspost,
+					Description: "Publish announcements to servers following the channel (announcement channels only)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        optionUpdates,
+					Description: "What to do when a feed changes an item that was already announced",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Ignore the change", Value: string(UpdateModeOff)},
+						{Name: "Edit the announcement", Value: string(UpdateModeEdit)},
+						{Name: "Announce that the item was updated", Value: string(UpdateModeNotice)},
+					},
+				},
+			},
+		},
+		{
+			Name:                     commandList,
+			Description:              "List every collection in this server and its feeds",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+		},
+		{
+			Name:                     commandImport,
+			Description:              "Subscribe to every feed in an OPML file, with a collection for each folder",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        optionFile,
+					Description: "OPML file exported from another feed reader or bot",
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Required:    true,
+				},
+				{
+					Name:        optionChannel,
+					Description: "Channel where new items in new collections will be announced to",
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					ChannelTypes: []discordgo.ChannelType{
+						discordgo.ChannelTypeGuildText,
+						discordgo.ChannelTypeGuildNews,
+						discordgo.ChannelTypeGuildNewsThread,
+						discordgo.ChannelTypeGuildPublicThread,
+						discordgo.ChannelTypeGuildPrivateThread,
+						discordgo.ChannelTypeGuildForum,
+					},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:                     commandExport,
+			Description:              "Download every collection in this server as an OPML file",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+		},
+		{
+			Name:                     commandFilter,
+			Description:              "Only announce items in a collection that match (or don't match) a filter",
+			DMPermission:             &dmPermission,
+			DefaultMemberPermissions: &memberPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        subcommandAdd,
+					Description: "Add a filter to a collection",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:         optionCollectionName,
+							Description:  "Collection to filter",
+							Type:         discordgo.ApplicationCommandOptionString,
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Name:        optionFilterMode,
+							Description: "Whether matching items are announced or skipped",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Only announce matching items", Value: filterModeInclude},
+								{Name: "Skip matching items", Value: filterModeExclude},
+							},
+						},
+						{
+							Name:        optionFilterPattern,
+							Description: "Keyword or regular expression to match",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        optionFilterField,
+							Description: "What part of the item to match against (default: any)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Any", Value: filterFieldAny},
+								{Name: "Title", Value: filterFieldTitle},
+								{Name: "Description", Value: filterFieldDescription},
+								{Name: "Categories", Value: filterFieldCategories},
+								{Name: "Author", Value: filterFieldAuthor},
+							},
+						},
+						{
+							Name:        optionFilterKind,
+							Description: "How to interpret the pattern (default: keyword)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Keyword (case-insensitive)", Value: filterKindKeyword},
+								{Name: "Regular expression", Value: filterKindRegex},
+							},
+						},
+					},
+				},
+				{
+					Name:        subcommandRemove,
+					Description: "Remove a filter from a collection",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:         optionCollectionName,
+							Description:  "Collection to change",
+							Type:         discordgo.ApplicationCommandOptionString,
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Name:        optionFilterID,
+							Description: "ID of the filter, as shown by /filter list",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        subcommandList,
+					Description: "List the filters on a collection",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:         optionCollectionName,
+							Description:  "Collection to show",
+							Type:         discordgo.ApplicationCommandOptionString,
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+			},
+		},
 	}
 )
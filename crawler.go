@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCrawlerWorkers   = 8
+	defaultCrawlerPerHost   = 2
+	defaultCrawlerHostDelay = time.Second
+	defaultCrawlerTimeout   = 10 * time.Second
+)
+
+// Crawler fans feed fetches out across a bounded pool of workers while
+// staying polite to each host: no more than PerHost requests to the same
+// host are in flight at once, and consecutive requests to a host start at
+// least HostDelay apart.
+type Crawler struct {
+	Client *http.Client
+
+	Workers   int
+	PerHost   int
+	HostDelay time.Duration
+}
+
+// Run calls fetch once for every feed and blocks until they have all
+// finished or ctx is cancelled. fetch is called from multiple goroutines.
+func (c *Crawler) Run(ctx context.Context, feeds []Feed, fetch func(context.Context, *Feed)) {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	perHost := c.PerHost
+	if perHost <= 0 {
+		perHost = 1
+	}
+
+	hosts := &hostLimiters{
+		perHost: perHost,
+		delay:   c.HostDelay,
+		hosts:   make(map[string]*hostLimiter),
+	}
+
+	jobs := make(chan *Feed)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for feed := range jobs {
+				release, err := hosts.acquire(ctx, feedHost(feed.Link))
+				if err != nil {
+					continue
+				}
+
+				fetch(ctx, feed)
+				release()
+			}
+		}()
+	}
+
+	for _, feed := range interleaveByHost(feeds) {
+		feed := feed
+		select {
+		case jobs <- &feed:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+type hostLimiters struct {
+	perHost int
+	delay   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+type hostLimiter struct {
+	slots chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// acquire blocks until a request to host may start. The returned func
+// must be called once the request has finished.
+func (h *hostLimiters) acquire(ctx context.Context, host string) (func(), error) {
+	h.mu.Lock()
+	limiter, ok := h.hosts[host]
+	if !ok {
+		limiter = &hostLimiter{slots: make(chan struct{}, h.perHost)}
+		h.hosts[host] = limiter
+	}
+	h.mu.Unlock()
+
+	select {
+	case limiter.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	release := func() { <-limiter.slots }
+
+	// A start time is only claimed once the wait for it is over, so a
+	// request that wakes up late doesn't crowd the one after it.
+	for {
+		limiter.mu.Lock()
+		now := time.Now()
+		wait := limiter.next.Sub(now)
+		if wait <= 0 {
+			limiter.next = now.Add(h.delay)
+			limiter.mu.Unlock()
+			return release, nil
+		}
+		limiter.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func feedHost(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// interleaveByHost reorders feeds round-robin by host so that workers
+// spread out across hosts instead of queueing behind the same one.
+func interleaveByHost(feeds []Feed) []Feed {
+	var (
+		order  []string
+		byHost = make(map[string][]Feed)
+	)
+
+	for _, feed := range feeds {
+		host := feedHost(feed.Link)
+		if _, ok := byHost[host]; !ok {
+			order = append(order, host)
+		}
+		byHost[host] = append(byHost[host], feed)
+	}
+
+	interleaved := make([]Feed, 0, len(feeds))
+	for len(interleaved) < len(feeds) {
+		for _, host := range order {
+			queue := byHost[host]
+			if len(queue) == 0 {
+				continue
+			}
+			interleaved = append(interleaved, queue[0])
+			byHost[host] = queue[1:]
+		}
+	}
+
+	return interleaved
+}
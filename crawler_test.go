@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCrawlerRun(t *testing.T) {
+	var feeds []Feed
+	for i := 0; i < 6; i++ {
+		feeds = append(feeds,
+			Feed{ID: int64(len(feeds)), Link: fmt.Sprintf("https://a.example.com/%d.xml", i)},
+			Feed{ID: int64(len(feeds) + 1), Link: fmt.Sprintf("https://B.example.com/%d.xml", i)},
+		)
+	}
+
+	const (
+		perHost = 2
+		delay   = 5 * time.Millisecond
+	)
+
+	c := &Crawler{Workers: 4, PerHost: perHost, HostDelay: delay}
+
+	var (
+		mu       sync.Mutex
+		inFlight = make(map[string]int)
+		starts   = make(map[string][]time.Time)
+		visited  = make(map[int64]int)
+		overrun  atomic.Bool
+	)
+
+	c.Run(context.Background(), feeds, func(ctx context.Context, feed *Feed) {
+		host := feedHost(feed.Link)
+		start := time.Now()
+
+		mu.Lock()
+		inFlight[host]++
+		if inFlight[host] > perHost {
+			overrun.Store(true)
+		}
+		starts[host] = append(starts[host], start)
+		visited[feed.ID]++
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		inFlight[host]--
+		mu.Unlock()
+	})
+
+	if overrun.Load() {
+		t.Errorf("more than %d concurrent requests to the same host", perHost)
+	}
+
+	for _, feed := range feeds {
+		if visited[feed.ID] != 1 {
+			t.Errorf("want feed %d fetched once, fetched %d times", feed.ID, visited[feed.ID])
+		}
+	}
+
+	for host, times := range starts {
+		// Concurrent requests may record their start out of order.
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+		for i := 1; i < len(times); i++ {
+			// Allow for a little scheduling jitter.
+			if gap := times[i].Sub(times[i-1]); gap < delay-time.Millisecond {
+				t.Errorf("requests to %s started %v apart, want at least %v", host, gap, delay)
+			}
+		}
+	}
+}
+
+func TestCrawlerRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	feeds := []Feed{
+		{ID: 1, Link: "https://example.com/1.xml"},
+		{ID: 2, Link: "https://example.com/2.xml"},
+	}
+
+	c := &Crawler{Workers: 1, PerHost: 1, HostDelay: time.Hour}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, feeds, func(ctx context.Context, feed *Feed) {})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return after the context was cancelled")
+	}
+}
+
+func TestInterleaveByHost(t *testing.T) {
+	feeds := []Feed{
+		{ID: 1, Link: "https://a.example.com/1"},
+		{ID: 2, Link: "https://a.example.com/2"},
+		{ID: 3, Link: "https://a.example.com/3"},
+		{ID: 4, Link: "https://b.example.com/1"},
+		{ID: 5, Link: "https://c.example.com/1"},
+		{ID: 6, Link: "https://b.example.com/2"},
+	}
+
+	var got []int64
+	for _, feed := range interleaveByHost(feeds) {
+		got = append(got, feed.ID)
+	}
+
+	want := []int64{1, 4, 5, 2, 6, 3}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want order %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	deliveryPending = "pending"
+	deliverySent    = "sent"
+	deliverySkipped = "skipped"
+	deliveryFailed  = "failed"
+)
+
+// Deliveries is the outbound queue of announcements. A row is queued for
+// every subscription when an article is ingested, and it is only marked
+// sent once Discord has accepted the message, so a failure anywhere in
+// between is retried instead of being lost or announced again forever.
+type Deliveries struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// Claim leases up to limit pending deliveries that are due at now. Claimed
+// deliveries aren't handed out again until lease has passed, which lets
+// several goose processes drain the queue without announcing an item
+// twice, and lets another process pick up the work if this one dies
+// before marking them sent.
+func (d *Deliveries) Claim(now time.Time, lease time.Duration, limit int) ([]Notification, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT
+			deliveries.id,
+			deliveries.attempts,
+			deliveries.is_update,
+			deliveries.discord_message_id,
+			deliveries.discord_channel_id,
+			subscriptions.id,
+			collections.id,
+			collections.server_id,
+			collections.channel_id,
+			collections.channel_type,
+			collections.crosspost,
+			collections.name,
+			collections.template,
+			collections.update_mode,
+			feeds.title,
+			articles.id,
+			articles.feed_id,
+			articles.guid,
+			articles.title,
+			articles.link,
+			articles.pub_date,
+			articles.author,
+			articles.description,
+			articles.image_url,
+			articles.categories,
+			articles.date_source,
+			articles.first_seen,
+			articles.content_hash,
+			articles.updated_at,
+			articles.media_url,
+			articles.media_type,
+			articles.episode,
+			articles.season,
+			articles.duration_secs
+		FROM deliveries
+		INNER JOIN subscriptions ON deliveries.subscription_id=subscriptions.id
+		INNER JOIN collections ON subscriptions.collection_id=collections.id
+		INNER JOIN articles ON deliveries.article_id=articles.id
+		INNER JOIN feeds ON articles.feed_id=feeds.id
+		WHERE deliveries.status = $1 AND deliveries.not_before <= $2
+		ORDER BY articles.pub_date ASC, deliveries.id ASC
+		LIMIT $3`
+	args := []any{deliveryPending, now.UTC(), limit}
+
+	// SQLite locks the whole database for writes, so only PostgreSQL
+	// needs to be told to leave rows claimed by others alone.
+	if d.dialect == dialectPostgres {
+		stmt += ` FOR UPDATE OF deliveries SKIP LOCKED`
+	}
+
+	rows, err := tx.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+
+	for rows.Next() {
+		var n Notification
+		var updated sql.NullTime
+		var durationSecs int64
+		err := rows.Scan(&n.DeliveryID, &n.Attempts, &n.Update, &n.MessageID, &n.MessageChannel, &n.SubscriptionID, &n.CollectionID, &n.ServerID, &n.ChannelID, &n.ChannelType, &n.Crosspost, &n.CollectionName, &n.Template, &n.UpdateMode, &n.FeedTitle,
+			&n.Article.ID, &n.Article.FeedID, &n.Article.GUID, &n.Article.Title, &n.Article.Link, &n.Article.Published, &n.Article.Author, &n.Article.Description, &n.Article.ImageURL, pq.Array(&n.Article.Categories), &n.Article.DateSource, &n.Article.FirstSeen,
+			&n.Article.ContentHash, &updated, &n.Article.MediaURL, &n.Article.MediaType, &n.Article.Episode, &n.Article.Season, &durationSecs)
+		if err != nil {
+			return nil, err
+		}
+		n.Article.Updated = updated.Time
+		n.Article.Duration = time.Duration(durationSecs) * time.Second
+
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	stmt = `UPDATE deliveries SET attempts = attempts + 1, not_before = $2 WHERE id = $1`
+
+	for i := range notifications {
+		n := &notifications[i]
+
+		_, err := tx.Exec(stmt, n.DeliveryID, now.Add(lease).UTC())
+		if err != nil {
+			return nil, err
+		}
+		n.Attempts++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// Sent marks a delivery as announced by the Discord message messageID in
+// channelID.
+func (d *Deliveries) Sent(id int64, channelID, messageID string) error {
+	stmt := `UPDATE deliveries SET status = $2, discord_channel_id = $3, discord_message_id = $4, last_error = '' WHERE id = $1`
+	args := []any{id, deliverySent, channelID, messageID}
+
+	_, err := d.db.Exec(stmt, args...)
+
+	return err
+}
+
+// Skip marks a delivery as one that should never be announced, e.g.
+// because the subscription's filters reject it.
+func (d *Deliveries) Skip(id int64) error {
+	stmt := `UPDATE deliveries SET status = $2 WHERE id = $1`
+	args := []any{id, deliverySkipped}
+
+	_, err := d.db.Exec(stmt, args...)
+
+	return err
+}
+
+// Retry puts a delivery back in the queue to be attempted again after
+// notBefore.
+func (d *Deliveries) Retry(id int64, cause error, notBefore time.Time) error {
+	stmt := `UPDATE deliveries SET not_before = $2, last_error = $3 WHERE id = $1`
+	args := []any{id, notBefore.UTC(), cause.Error()}
+
+	_, err := d.db.Exec(stmt, args...)
+
+	return err
+}
+
+// Fail gives up on a delivery.
+func (d *Deliveries) Fail(id int64, cause error) error {
+	stmt := `UPDATE deliveries SET status = $2, last_error = $3 WHERE id = $1`
+	args := []any{id, deliveryFailed, cause.Error()}
+
+	_, err := d.db.Exec(stmt, args...)
+
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// Discord limits the name of a forum post to this many characters.
+	forumPostNameLimit = 100
+
+	forumPostArchiveMinutes = 24 * 60
+
+	deliveryBatchSize = 50
+
+	// deliveryLease is how long a claimed delivery is reserved for the
+	// process that claimed it. It must comfortably cover sending a whole
+	// batch through the rate limiter.
+	deliveryLease = 5 * time.Minute
+
+	maxDeliveryAttempts = 5
+
+	baseDeliveryBackoff = time.Minute
+	maxDeliveryBackoff  = time.Hour
+)
+
+// deliveryBackoff returns how long to wait before retrying a delivery
+// that has been attempted n times. The wait doubles with every attempt.
+func deliveryBackoff(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+
+	backoff := baseDeliveryBackoff
+	for i := 1; i < n; i++ {
+		backoff *= 2
+		if backoff >= maxDeliveryBackoff {
+			return maxDeliveryBackoff
+		}
+	}
+
+	return backoff
+}
+
+// undeliverable reports whether err means retrying the delivery can't
+// succeed, e.g. because the channel was deleted or goose lost access to it.
+func undeliverable(err error) bool {
+	var rerr *discordgo.RESTError
+	if !errors.As(err, &rerr) || rerr.Response == nil {
+		return false
+	}
+
+	switch rerr.Response.StatusCode {
+	case http.StatusForbidden, http.StatusNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// send delivers message to a subscription's channel through the shard
+// responsible for guildID and returns the ID of the message that was
+// created. Forum channels can't hold messages directly, so a new post
+// named title is started for each message instead. Messages sent to news
+// channels are published to following servers when crosspost is set.
+func (b *Bot) send(guildID, channelID string, channelType discordgo.ChannelType, crosspost bool, title string, message *discordgo.MessageSend) (string, error) {
+	session := b.shards.ForGuild(guildID)
+
+	if channelType == discordgo.ChannelTypeGuildForum {
+		name := strings.TrimSpace(title)
+		if name == "" {
+			name = "🪿 New item"
+		}
+
+		thread, err := session.ForumThreadStartComplex(channelID, &discordgo.ThreadStart{
+			Name:                truncate(name, forumPostNameLimit),
+			AutoArchiveDuration: forumPostArchiveMinutes,
+		}, message)
+		if err != nil {
+			return "", fmt.Errorf("start forum post: %w", err)
+		}
+
+		// The first message in a forum post shares its ID with the post.
+		return thread.ID, nil
+	}
+
+	sent, err := session.ChannelMessageSendComplex(channelID, message)
+	if err != nil {
+		return "", fmt.Errorf("send message: %w", err)
+	}
+
+	if crosspost && channelType == discordgo.ChannelTypeGuildNews {
+		_, err := session.ChannelMessageCrosspost(channelID, sent.ID)
+		if err != nil {
+			// The announcement was still made in this server, so don't
+			// fail the delivery over it.
+			slog.With(
+				slog.String("channel_id", channelID),
+				slog.String("message_id", sent.ID),
+				slog.Any("err", err),
+			).Warn("crosspost message")
+		}
+	}
+
+	return sent.ID, nil
+}
+
+// messageChannel returns the channel that a message sent to channelID
+// ended up in. Each forum post is a channel of its own, which shares its
+// ID with the message that started it.
+func messageChannel(channelID string, channelType discordgo.ChannelType, messageID string) string {
+	if channelType == discordgo.ChannelTypeGuildForum {
+		return messageID
+	}
+	return channelID
+}
+
+// edit replaces the announcement messageID in channelID with message.
+func (b *Bot) edit(guildID, channelID, messageID string, message *discordgo.MessageSend) error {
+	session := b.shards.ForGuild(guildID)
+
+	_, err := session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      messageID,
+		Channel: channelID,
+		Content: &message.Content,
+		Embeds:  message.Embeds,
+	})
+	if err != nil {
+		return fmt.Errorf("edit message: %w", err)
+	}
+
+	return nil
+}
+
+// sendNotice sends message as a reply to the announcement messageID in
+// channelID, or just into the post when the announcement started a forum
+// post. Notices are never crossposted, since the original announcement
+// already was.
+func (b *Bot) sendNotice(guildID, channelID, messageID string, message *discordgo.MessageSend) error {
+	session := b.shards.ForGuild(guildID)
+
+	if channelID != messageID {
+		message.Reference = &discordgo.MessageReference{
+			MessageID: messageID,
+			ChannelID: channelID,
+			GuildID:   guildID,
+		}
+	}
+
+	_, err := session.ChannelMessageSendComplex(channelID, message)
+	if err != nil {
+		return fmt.Errorf("send notice: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/time/rate"
+)
+
+func TestDeliveryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 0},
+		{attempts: 1, want: time.Minute},
+		{attempts: 2, want: 2 * time.Minute},
+		{attempts: 4, want: 8 * time.Minute},
+		{attempts: 6, want: 32 * time.Minute},
+		{attempts: 7, want: maxDeliveryBackoff},
+		{attempts: 1000, want: maxDeliveryBackoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d attempts", tt.attempts), func(t *testing.T) {
+			got := deliveryBackoff(tt.attempts)
+			if got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUndeliverable(t *testing.T) {
+	restError := func(status int) error {
+		return fmt.Errorf("send message: %w", &discordgo.RESTError{
+			Response: &http.Response{StatusCode: status},
+		})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "missing access", err: restError(http.StatusForbidden), want: true},
+		{name: "unknown channel", err: restError(http.StatusNotFound), want: true},
+		{name: "rate limited", err: restError(http.StatusTooManyRequests), want: false},
+		{name: "server error", err: restError(http.StatusBadGateway), want: false},
+		{name: "network error", err: errors.New("connection reset by peer"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := undeliverable(tt.err)
+			if got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// discordRecorder stands in for Discord's API, recording the requests
+// goose makes and answering them with canned objects.
+type discordRecorder struct {
+	requests []string
+	bodies   []string
+	fail     map[string]int
+}
+
+func (d *discordRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/api/v"+discordgo.APIVersion)
+	d.requests = append(d.requests, req)
+
+	var sent []byte
+	if r.Body != nil {
+		var err error
+		sent, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.bodies = append(d.bodies, string(sent))
+
+	status, body := http.StatusOK, `{"id":"42"}`
+	if code, ok := d.fail[req]; ok {
+		status, body = code, `{"message":"honk","code":0}`
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func newRecordingBot(t *testing.T, d *discordRecorder) *Bot {
+	t.Helper()
+
+	session, err := discordgo.New("Bot token")
+	if err != nil {
+		t.Fatalf("discordgo.New: %v", err)
+	}
+	session.Client = &http.Client{Transport: d}
+	session.MaxRestRetries = 0
+
+	return &Bot{shards: &Shards{Count: 1, Sessions: map[int]*discordgo.Session{0: session}}}
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name        string
+		channelType discordgo.ChannelType
+		crosspost   bool
+		fail        map[string]int
+		want        []string
+	}{
+		{
+			name:        "text channel",
+			channelType: discordgo.ChannelTypeGuildText,
+			want:        []string{"POST /channels/1/messages"},
+		},
+		{
+			name:        "crosspost to text channel",
+			channelType: discordgo.ChannelTypeGuildText,
+			crosspost:   true,
+			want:        []string{"POST /channels/1/messages"},
+		},
+		{
+			name:        "news channel",
+			channelType: discordgo.ChannelTypeGuildNews,
+			want:        []string{"POST /channels/1/messages"},
+		},
+		{
+			name:        "crosspost to news channel",
+			channelType: discordgo.ChannelTypeGuildNews,
+			crosspost:   true,
+			want:        []string{"POST /channels/1/messages", "POST /channels/1/messages/42/crosspost"},
+		},
+		{
+			name:        "crosspost fails",
+			channelType: discordgo.ChannelTypeGuildNews,
+			crosspost:   true,
+			fail:        map[string]int{"POST /channels/1/messages/42/crosspost": http.StatusForbidden},
+			want:        []string{"POST /channels/1/messages", "POST /channels/1/messages/42/crosspost"},
+		},
+		{
+			name:        "forum channel",
+			channelType: discordgo.ChannelTypeGuildForum,
+			want:        []string{"POST /channels/1/threads"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &discordRecorder{fail: tt.fail}
+			b := newRecordingBot(t, d)
+
+			id, err := b.send("guild1", "1", tt.channelType, tt.crosspost, "Honk", &discordgo.MessageSend{Content: "honk"})
+			if err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			if id != "42" {
+				t.Errorf("want message ID 42, got %q", id)
+			}
+			if !reflect.DeepEqual(tt.want, d.requests) {
+				t.Errorf("want requests %q, got %q", tt.want, d.requests)
+			}
+		})
+	}
+
+	d := &discordRecorder{fail: map[string]int{"POST /channels/1/threads": http.StatusForbidden}}
+	b := newRecordingBot(t, d)
+
+	_, err := b.send("guild1", "1", discordgo.ChannelTypeGuildForum, false, "Honk", &discordgo.MessageSend{Content: "honk"})
+	if !undeliverable(err) {
+		t.Errorf("want an undeliverable error when the forum post can't be started, got %v", err)
+	}
+}
+
+// newFilteringBot returns a bot announcing into a collection that
+// follows updates and leaves out items with "retracted" in their title.
+func newFilteringBot(t *testing.T) (*Bot, *discordRecorder, Stores, *Feed) {
+	t.Helper()
+
+	stores := NewMemoryStores()
+
+	d := &discordRecorder{}
+	b := newRecordingBot(t, d)
+	b.articles = stores.Articles
+	b.filters = stores.Filters
+	b.deliveries = stores.Deliveries
+	b.subscriptions = stores.Subscriptions
+	b.rateLimiter = rate.NewLimiter(rate.Inf, 1)
+
+	link, err := url.Parse("http://example.com/feed")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	feed, err := stores.Feeds.Create(link, time.Time{})
+	if err != nil {
+		t.Fatalf("Create feed: %v", err)
+	}
+
+	c, err := stores.Collections.Create(Collection{ServerID: "guild1", Name: "edits", ChannelID: "1", UpdateMode: UpdateModeEdit})
+	if err != nil {
+		t.Fatalf("Create collection: %v", err)
+	}
+
+	_, err = stores.Subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID})
+	if err != nil {
+		t.Fatalf("Create subscription: %v", err)
+	}
+
+	_, err = stores.Filters.Create(Filter{CollectionID: c.ID, Field: filterFieldTitle, Mode: filterModeExclude, Kind: filterKindKeyword, Pattern: "retracted"})
+	if err != nil {
+		t.Fatalf("Create filter: %v", err)
+	}
+
+	return b, d, stores, feed
+}
+
+func TestDeliverFiltered(t *testing.T) {
+	b, d, stores, feed := newFilteringBot(t)
+
+	_, err := stores.Articles.Create(Article{FeedID: feed.ID, GUID: "honk-1", Title: "Goose spotted (retracted)", Published: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("Create article: %v", err)
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("want one notification, got [%+v] err=%v", claimed, err)
+	}
+
+	err = b.deliver(context.Background(), &claimed[0], make(map[int64]*FilterSet))
+	if err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if len(d.requests) != 0 {
+		t.Errorf("want a filtered item left unannounced, got requests %q", d.requests)
+	}
+
+	subs, err := stores.Subscriptions.ListByFeed(feed.ID)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("want one subscription, got [%+v] err=%v", subs, err)
+	}
+	if !subs[0].LastPubDate.IsZero() {
+		t.Errorf("want a filtered item to not count as the last announced one, got %v", subs[0].LastPubDate)
+	}
+}
+
+func TestDeliverFilteredUpdate(t *testing.T) {
+	b, d, stores, feed := newFilteringBot(t)
+
+	art := Article{FeedID: feed.ID, GUID: "honk-1", Title: "Goose spotted", Link: "http://example.com/1", ContentHash: "v1"}
+	_, err := stores.Articles.Create(art)
+	if err != nil {
+		t.Fatalf("Create article: %v", err)
+	}
+
+	claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("want one notification, got [%+v] err=%v", claimed, err)
+	}
+
+	err = stores.Deliveries.Sent(claimed[0].DeliveryID, claimed[0].ChannelID, "message-1")
+	if err != nil {
+		t.Fatalf("Sent: %v", err)
+	}
+
+	art.Title = "Goose spotted (retracted)"
+	art.ContentHash = "v2"
+	_, err = stores.Articles.Update(art)
+	if err != nil {
+		t.Fatalf("Update article: %v", err)
+	}
+
+	claimed, err = stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 || !claimed[0].Update {
+		t.Fatalf("want one update, got [%+v] err=%v", claimed, err)
+	}
+
+	err = b.deliver(context.Background(), &claimed[0], make(map[int64]*FilterSet))
+	if err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if len(d.requests) != 0 {
+		t.Errorf("want the announcement of a filtered update left alone, got requests %q", d.requests)
+	}
+
+	claimed, err = stores.Deliveries.Claim(time.Now().Add(2*time.Minute), time.Minute, 10)
+	if err != nil || len(claimed) != 0 {
+		t.Errorf("want the filtered update marked sent, got [%+v] err=%v", claimed, err)
+	}
+}
+
+func TestDeliverUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        UpdateMode
+		channelType discordgo.ChannelType
+		sentIn      string
+		fail        map[string]int
+		want        []string
+		wantReply   bool
+		wantChannel string
+		wantMessage string
+	}{
+		{
+			name:        "edit",
+			mode:        UpdateModeEdit,
+			sentIn:      "2",
+			want:        []string{"PATCH /channels/2/messages/7"},
+			wantChannel: "2",
+			wantMessage: "7",
+		},
+		{
+			name:        "edit after moving",
+			mode:        UpdateModeEdit,
+			sentIn:      "1",
+			want:        []string{"PATCH /channels/1/messages/7"},
+			wantChannel: "1",
+			wantMessage: "7",
+		},
+		{
+			name:        "edit in deleted channel",
+			mode:        UpdateModeEdit,
+			sentIn:      "1",
+			fail:        map[string]int{"PATCH /channels/1/messages/7": http.StatusNotFound},
+			want:        []string{"PATCH /channels/1/messages/7", "POST /channels/2/messages"},
+			wantChannel: "2",
+			wantMessage: "42",
+		},
+		{
+			name:        "notice",
+			mode:        UpdateModeNotice,
+			sentIn:      "2",
+			want:        []string{"POST /channels/2/messages"},
+			wantReply:   true,
+			wantChannel: "2",
+			wantMessage: "7",
+		},
+		{
+			name:        "notice in forum post",
+			mode:        UpdateModeNotice,
+			channelType: discordgo.ChannelTypeGuildForum,
+			sentIn:      "7",
+			want:        []string{"POST /channels/7/messages"},
+			wantChannel: "7",
+			wantMessage: "7",
+		},
+		{
+			name:        "notice after moving",
+			mode:        UpdateModeNotice,
+			sentIn:      "1",
+			want:        []string{"POST /channels/2/messages"},
+			wantChannel: "2",
+			wantMessage: "42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := NewMemoryStores()
+
+			d := &discordRecorder{fail: tt.fail}
+			b := newRecordingBot(t, d)
+			b.filters = stores.Filters
+			b.deliveries = stores.Deliveries
+			b.rateLimiter = rate.NewLimiter(rate.Inf, 1)
+
+			link, err := url.Parse("http://example.com/feed")
+			if err != nil {
+				t.Fatalf("url.Parse: %v", err)
+			}
+
+			feed, err := stores.Feeds.Create(link, time.Time{})
+			if err != nil {
+				t.Fatalf("Create feed: %v", err)
+			}
+
+			// The collection announces in channel 2, but the item may have
+			// been announced in channel 1 before it moved.
+			c, err := stores.Collections.Create(Collection{ServerID: "guild1", Name: "honk", ChannelID: "2", ChannelType: tt.channelType, UpdateMode: tt.mode})
+			if err != nil {
+				t.Fatalf("Create collection: %v", err)
+			}
+
+			_, err = stores.Subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID})
+			if err != nil {
+				t.Fatalf("Create subscription: %v", err)
+			}
+
+			art := Article{FeedID: feed.ID, GUID: "honk-1", Title: "Goose spotted", Link: "http://example.com/1", ContentHash: "v1"}
+			_, err = stores.Articles.Create(art)
+			if err != nil {
+				t.Fatalf("Create article: %v", err)
+			}
+
+			// update changes the article and claims the update that queues.
+			update := func(hash string) Notification {
+				t.Helper()
+
+				art.ContentHash = hash
+				_, err := stores.Articles.Update(art)
+				if err != nil {
+					t.Fatalf("Update article: %v", err)
+				}
+
+				claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+				if err != nil || len(claimed) != 1 || !claimed[0].Update {
+					t.Fatalf("want one update, got [%+v] err=%v", claimed, err)
+				}
+				return claimed[0]
+			}
+
+			claimed, err := stores.Deliveries.Claim(time.Now(), time.Minute, 10)
+			if err != nil || len(claimed) != 1 {
+				t.Fatalf("want one notification, got [%+v] err=%v", claimed, err)
+			}
+
+			err = stores.Deliveries.Sent(claimed[0].DeliveryID, tt.sentIn, "7")
+			if err != nil {
+				t.Fatalf("Sent: %v", err)
+			}
+
+			n := update("v2")
+			err = b.deliver(context.Background(), &n, make(map[int64]*FilterSet))
+			if err != nil {
+				t.Fatalf("deliver: %v", err)
+			}
+
+			if !reflect.DeepEqual(tt.want, d.requests) {
+				t.Errorf("want requests %q, got %q", tt.want, d.requests)
+			}
+			if reply := strings.Contains(d.bodies[len(d.bodies)-1], "message_reference"); reply != tt.wantReply {
+				t.Errorf("want reply=%v, got request [%s]", tt.wantReply, d.bodies[len(d.bodies)-1])
+			}
+
+			// The next update goes to the announcement this one left.
+			n = update("v3")
+			if n.MessageChannel != tt.wantChannel || n.MessageID != tt.wantMessage {
+				t.Errorf("want the next update of message %q in %q, got message %q in %q", tt.wantMessage, tt.wantChannel, n.MessageID, n.MessageChannel)
+			}
+		})
+	}
+}
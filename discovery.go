@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/html"
+)
+
+const (
+	subscribePickPrefix = "subscribe_pick:"
+
+	// feedPicksMax is how many feeds fit in a select menu.
+	feedPicksMax = 25
+
+	// feedPickTimeout is how long a select menu of discovered feeds
+	// keeps working, which matches how long Discord lets a response be
+	// followed up.
+	feedPickTimeout = 15 * time.Minute
+
+	// feedSniffMax is how much of a response is read to tell whether
+	// it's a feed.
+	feedSniffMax = 64 << 10
+
+	// feedSizeMax is the most goose reads of a feed or web page.
+	feedSizeMax = 10 << 20
+
+	// feedProbeTimeout is how long the common feed paths on a site are
+	// given to answer, all together.
+	feedProbeTimeout = 3 * time.Second
+)
+
+// feedLinkTypes are the media types of <link rel="alternate"> tags that
+// point at feeds.
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// commonFeedPaths are where sites tend to keep their feed when their
+// pages don't link to it.
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml", "/index.xml"}
+
+// findFeedLinks lists the feeds that an HTML page links to with
+// <link rel="alternate"> tags, resolved against the page's URL.
+func findFeedLinks(page *url.URL, body io.Reader) []*url.URL {
+	base := page
+	var links []*url.URL
+	seen := make(map[string]bool)
+
+	z := html.NewTokenizer(body)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if !hasAttr {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			for {
+				key, val, more := z.TagAttr()
+				attrs[string(key)] = string(val)
+				if !more {
+					break
+				}
+			}
+
+			switch string(name) {
+			case "base":
+				if href, err := page.Parse(attrs["href"]); err == nil {
+					base = href
+				}
+			case "link":
+				if !hasToken(attrs["rel"], "alternate") || !feedLinkTypes[strings.ToLower(strings.TrimSpace(attrs["type"]))] || attrs["href"] == "" {
+					continue
+				}
+
+				link, err := base.Parse(attrs["href"])
+				if err != nil || !strings.Contains(link.Scheme, "http") || seen[link.String()] {
+					continue
+				}
+
+				seen[link.String()] = true
+				links = append(links, link)
+			}
+		}
+	}
+}
+
+// hasToken reports whether a space-separated list such as a rel
+// attribute contains token.
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverFeeds finds the feeds behind a web page. Feeds the page links
+// to come first; the common feed paths on the page's site are only tried
+// if the page doesn't link to any. They're tried all at once, so that a
+// slow site doesn't keep /subscribe waiting for each of them in turn.
+func (b *Bot) discoverFeeds(page *url.URL, body []byte) []*url.URL {
+	links := findFeedLinks(page, bytes.NewReader(body))
+	if len(links) > 0 {
+		if len(links) > feedPicksMax {
+			links = links[:feedPicksMax]
+		}
+		return links
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), feedProbeTimeout)
+	defer cancel()
+
+	probes := make([]*url.URL, len(commonFeedPaths))
+	found := make([]bool, len(commonFeedPaths))
+
+	var wg sync.WaitGroup
+	for n, p := range commonFeedPaths {
+		probes[n] = page.ResolveReference(&url.URL{Path: p})
+
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			found[n] = b.isFeed(ctx, probes[n])
+		}(n)
+	}
+	wg.Wait()
+
+	for n, link := range probes {
+		if found[n] {
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// isFeed reports whether link answers with something that looks like a
+// feed.
+func (b *Bot) isFeed(ctx context.Context, link *url.URL) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	rsp, err := b.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return false
+	}
+
+	return gofeed.DetectFeedType(io.LimitReader(rsp.Body, feedSniffMax)) != gofeed.FeedTypeUnknown
+}
+
+// feedPick is a subscription waiting for the user to pick which of the
+// discovered feeds it should be to.
+type feedPick struct {
+	UserID     string
+	Collection Collection
+	Links      []*url.URL
+	Expires    time.Time
+}
+
+// feedPicks holds the select menus of discovered feeds that are waiting
+// for an answer. They're only kept in memory, so if replicas run the same
+// shards and the answer reaches a different one than the question came
+// from, it's treated as expired and /subscribe has to be run again.
+type feedPicks struct {
+	mu      sync.Mutex
+	pending map[string]feedPick
+}
+
+func (fp *feedPicks) add(id string, pick feedPick) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.pending == nil {
+		fp.pending = make(map[string]feedPick)
+	}
+
+	for k, p := range fp.pending {
+		if time.Now().After(p.Expires) {
+			delete(fp.pending, k)
+		}
+	}
+
+	fp.pending[id] = pick
+}
+
+var (
+	errFeedPickExpired  = errors.New("feed pick expired")
+	errFeedPickNotAsked = errors.New("feed pick is someone else's")
+)
+
+// take removes and returns the pick with the given ID, as long as it
+// hasn't expired. Only the user who was asked can take it, anyone else
+// gets errFeedPickNotAsked and leaves it be.
+func (fp *feedPicks) take(id, userID string) (feedPick, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	pick, ok := fp.pending[id]
+	if !ok || time.Now().After(pick.Expires) {
+		delete(fp.pending, id)
+		return feedPick{}, errFeedPickExpired
+	}
+
+	if pick.UserID != userID {
+		return feedPick{}, errFeedPickNotAsked
+	}
+
+	delete(fp.pending, id)
+	return pick, nil
+}
+
+// interactionUserID returns who sent an interaction, whether it came from
+// a server or a direct message.
+func interactionUserID(i *discordgo.Interaction) string {
+	switch {
+	case i.Member != nil && i.Member.User != nil:
+		return i.Member.User.ID
+	case i.User != nil:
+		return i.User.ID
+	default:
+		return ""
+	}
+}
+
+func renderFeedPicks(id string, links []*url.URL) []discordgo.MessageComponent {
+	options := make([]discordgo.SelectMenuOption, 0, len(links))
+	for n, link := range links {
+		options = append(options, discordgo.SelectMenuOption{
+			Label: truncate(link.String(), 100),
+			Value: strconv.Itoa(n),
+		})
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    subscribePickPrefix + id,
+				Placeholder: "Pick a feed",
+				Options:     options,
+			},
+		}},
+	}
+}
+
+// askFeedPick asks the user which of the feeds found on a web page to
+// subscribe to, in the deferred response to their /subscribe.
+func (b *Bot) askFeedPick(s *discordgo.Session, i *discordgo.Interaction, c Collection, links []*url.URL) error {
+	b.feedPicks.add(i.ID, feedPick{
+		UserID:     interactionUserID(i),
+		Collection: c,
+		Links:      links,
+		Expires:    time.Now().Add(feedPickTimeout),
+	})
+
+	content := fmt.Sprintf("🪿 Curious honk? That's a web page with %d feeds on it. Which one should I add to the %q collection?", len(links), c.Name)
+	components := renderFeedPicks(i.ID, links)
+
+	_, err := s.InteractionResponseEdit(i, &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+	})
+	return err
+}
+
+// SubscribePick subscribes to the feed picked from the select menu sent
+// by askFeedPick.
+func (b *Bot) SubscribePick(s *discordgo.Session, i *discordgo.Interaction) {
+	data := i.MessageComponentData()
+	id := strings.TrimPrefix(data.CustomID, subscribePickPrefix)
+
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+		slog.String("pick_id", id),
+	)
+
+	update := func(msg string) {
+		err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    msg,
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
+		}
+	}
+
+	pick, err := b.feedPicks.take(id, interactionUserID(i))
+	switch {
+	case errors.Is(err, errFeedPickNotAsked):
+		// Answer only the user who clicked, and leave the menu for the
+		// one who was asked.
+		err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: `🪿 territorial honk. That question is for whoever ran /subscribe.`,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+		}
+		return
+	case err != nil:
+		update(`🪿 forgetful honk. That question has expired, try /subscribe again.`)
+		return
+	}
+
+	var link *url.URL
+	if len(data.Values) == 1 {
+		if n, err := strconv.Atoi(data.Values[0]); err == nil && n >= 0 && n < len(pick.Links) {
+			link = pick.Links[n]
+		}
+	}
+	if link == nil {
+		update(`🪿 cOnFuSeD hOnK! I don't know that feed.`)
+		return
+	}
+
+	logger = logger.With(
+		slog.String("feed", link.String()),
+		slog.String("collection_name", pick.Collection.Name),
+		slog.String("announce_channel_id", pick.Collection.ChannelID),
+	)
+
+	err = b.subscribeFeed(link, pick.Collection, false)
+	update(subscribeResult(logger, err, pick.Collection))
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestFindFeedLinks(t *testing.T) {
+	page, err := url.Parse("https://example.com/blog/post.html")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	doc := `<!DOCTYPE html>
+<html>
+<head>
+  <link rel="stylesheet" type="text/css" href="/style.css">
+  <link rel="alternate" type="application/rss+xml" title="RSS" href="/rss.xml">
+  <link rel="alternate" type="application/atom+xml" href="atom.xml" />
+  <link rel="ALTERNATE home" type="application/feed+json" href="https://feeds.example.com/feed.json">
+  <link rel="alternate" type="application/rss+xml" href="https://example.com/rss.xml">
+  <link rel="alternate" type="text/html" hreflang="fr" href="/fr/">
+  <link rel="alternate" type="application/rss+xml" href="javascript:void(0)">
+</head>
+<body><a href="/other.xml">Not a link tag</a></body>
+</html>`
+
+	links := findFeedLinks(page, strings.NewReader(doc))
+
+	want := []string{
+		"https://example.com/rss.xml",
+		"https://example.com/blog/atom.xml",
+		"https://feeds.example.com/feed.json",
+	}
+	if len(links) != len(want) {
+		t.Fatalf("want links %v, got %v", want, links)
+	}
+	for n := range want {
+		if links[n].String() != want[n] {
+			t.Fatalf("want links %v, got %v", want, links)
+		}
+	}
+
+	links = findFeedLinks(page, strings.NewReader(`<head><base href="https://cdn.example.com/"><link rel="alternate" type="application/rss+xml" href="feed"></head>`))
+	if len(links) != 1 || links[0].String() != "https://cdn.example.com/feed" {
+		t.Fatalf("want the link resolved against <base>, got %v", links)
+	}
+}
+
+func TestBotSubscribeDiscoversFeeds(t *testing.T) {
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.add("Honk", time.Now().Add(-time.Hour))
+
+	mux := http.NewServeMux()
+	mux.Handle("/feed", feedServer)
+	mux.Handle("/comments.xml", feedServer)
+	mux.HandleFunc("/one", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="alternate" type="application/rss+xml" href="/feed"></head></html>`)
+	})
+	mux.HandleFunc("/two", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+			<link rel="alternate" type="application/rss+xml" href="/feed">
+			<link rel="alternate" type="application/rss+xml" href="/comments.xml">
+		</head></html>`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<html><body>No link tags here</body></html>`)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	parse := func(s string) *url.URL {
+		link, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("url.Parse: %v", err)
+		}
+		return link
+	}
+
+	// The page links to a single feed, which is subscribed to directly.
+	err := b.subscribe(parse(srv.URL+"/one"), Collection{ServerID: "server1", Name: "one", ChannelID: "channel1"})
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when subscribing to a page with one feed", err)
+	}
+
+	_, err = stores.Feeds.GetByLink(srv.URL + "/feed")
+	if err != nil {
+		t.Fatalf("want the discovered feed to be subscribed to, got err=%v", err)
+	}
+
+	_, err = stores.Feeds.GetByLink(srv.URL + "/one")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want err=%v, got err=%v for the page itself", ErrNotFound, err)
+	}
+
+	// The page links to several feeds, so the user has to pick.
+	err = b.subscribe(parse(srv.URL+"/two"), Collection{ServerID: "server1", Name: "two", ChannelID: "channel1"})
+	var multi *ErrMultipleFeeds
+	if !errors.As(err, &multi) {
+		t.Fatalf("want err=%T, got err=%v when subscribing to a page with two feeds", multi, err)
+	}
+	if len(multi.Links) != 2 || multi.Links[1].String() != srv.URL+"/comments.xml" {
+		t.Fatalf("want both feeds offered, got %v", multi.Links)
+	}
+
+	// The page doesn't link to a feed, but the site has one at /feed.
+	err = b.subscribe(parse(srv.URL+"/"), Collection{ServerID: "server1", Name: "home", ChannelID: "channel2"})
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when subscribing to a site with a feed at a common path", err)
+	}
+
+	home, err := stores.Collections.GetByName("server1", "home")
+	if err != nil {
+		t.Fatalf("GetByName: %v", err)
+	}
+
+	subs, err := stores.Subscriptions.ListByCollection(home.ID)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("want one subscription, got %+v err=%v", subs, err)
+	}
+
+	feed, err := stores.Feeds.Get(subs[0].FeedID)
+	if err != nil || feed.Link != srv.URL+"/feed" {
+		t.Fatalf("want the feed at /feed, got %+v err=%v", feed, err)
+	}
+}
+
+func TestBotSubscribeWithoutFeeds(t *testing.T) {
+	stores := NewMemoryStores()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body>Nothing to see here</body></html>`)
+	}))
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if !errors.Is(err, ErrNotRSSFeed) {
+		t.Fatalf("want err=%v, got err=%v", ErrNotRSSFeed, err)
+	}
+}
+
+func TestFeedPicks(t *testing.T) {
+	var picks feedPicks
+
+	picks.add("fresh", feedPick{UserID: "user1", Collection: Collection{Name: "honk"}, Expires: time.Now().Add(time.Minute)})
+	picks.add("stale", feedPick{UserID: "user1", Expires: time.Now().Add(-time.Minute)})
+
+	// Someone else can't answer, and doesn't take the question away.
+	_, err := picks.take("fresh", "user2")
+	if !errors.Is(err, errFeedPickNotAsked) {
+		t.Fatalf("want err=%v, got err=%v for another user", errFeedPickNotAsked, err)
+	}
+
+	pick, err := picks.take("fresh", "user1")
+	if err != nil || pick.Collection.Name != "honk" {
+		t.Fatalf("want the pending pick, got %+v err=%v", pick, err)
+	}
+
+	_, err = picks.take("fresh", "user1")
+	if !errors.Is(err, errFeedPickExpired) {
+		t.Fatalf("want a pick to be taken only once, got err=%v", err)
+	}
+
+	_, err = picks.take("stale", "user1")
+	if !errors.Is(err, errFeedPickExpired) {
+		t.Fatalf("want an expired pick to be gone, got err=%v", err)
+	}
+}
+
+func TestBotSubscribeTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Honk Times</title><description>`)
+		fmt.Fprint(w, strings.Repeat("honk ", feedSizeMax/5))
+		fmt.Fprint(w, `</description></channel></rss>`)
+	}))
+	defer srv.Close()
+
+	b := newTestBot(NewMemoryStores(), srv.Client())
+
+	link, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"})
+	if !errors.Is(err, ErrFeedTooLarge) {
+		t.Fatalf("want err=%v, got err=%v", ErrFeedTooLarge, err)
+	}
+}
+
+func TestBotSubscribePickByAnotherUser(t *testing.T) {
+	d := &discordRecorder{}
+	b := newTestBot(NewMemoryStores(), http.DefaultClient)
+	b.shards = newRecordingBot(t, d).shards
+
+	link, err := url.Parse("https://example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	b.feedPicks.add("question1", feedPick{
+		UserID:     "user1",
+		Collection: Collection{ServerID: "server1", Name: "honk", ChannelID: "channel1"},
+		Links:      []*url.URL{link},
+		Expires:    time.Now().Add(time.Minute),
+	})
+
+	b.SubscribePick(b.shards.Any(), &discordgo.Interaction{
+		ID:      "interaction2",
+		Token:   "token",
+		Type:    discordgo.InteractionMessageComponent,
+		GuildID: "server1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "user2"}},
+		Data: discordgo.MessageComponentInteractionData{
+			CustomID: subscribePickPrefix + "question1",
+			Values:   []string{"0"},
+		},
+	})
+
+	if len(d.bodies) != 1 || !strings.Contains(d.bodies[0], "whoever ran /subscribe") || !strings.Contains(d.bodies[0], `"flags":64`) {
+		t.Fatalf("want a private refusal, got %q", d.bodies)
+	}
+
+	_, err = b.feedPicks.take("question1", "user1")
+	if err != nil {
+		t.Fatalf("want the question left for the user who was asked, got err=%v", err)
+	}
+}
+
+func TestBotSubscribeDefersResponse(t *testing.T) {
+	feedServer := &testFeedServer{}
+	feedServer.add("Honk", time.Now().Add(-time.Hour))
+
+	srv := httptest.NewServer(feedServer)
+	defer srv.Close()
+
+	d := &discordRecorder{}
+	b := newTestBot(NewMemoryStores(), srv.Client())
+	b.shards = newRecordingBot(t, d).shards
+
+	b.Subscribe(b.shards.Any(), newCommand("subscribe",
+		stringOption(optionFeed, srv.URL),
+		stringOption(optionCollectionName, "honk"),
+		channelOption("channel1"),
+	))
+
+	// Discord is told the answer is coming before the feed is fetched,
+	// and the answer then replaces that.
+	want := []string{"POST /interactions/interaction1/token/callback", "PATCH /webhooks//token/messages/@original"}
+	if !reflect.DeepEqual(want, d.requests) {
+		t.Fatalf("want requests %q, got %q", want, d.requests)
+	}
+	if !strings.Contains(d.bodies[0], fmt.Sprintf(`"type":%d`, discordgo.InteractionResponseDeferredChannelMessageWithSource)) {
+		t.Fatalf("want a deferred response, got %s", d.bodies[0])
+	}
+	if !strings.Contains(d.bodies[1], "Affirmative HONK") {
+		t.Fatalf("want the result in the response, got %s", d.bodies[1])
+	}
+}
+
+func TestDiscoverFeedsProbesAtOnce(t *testing.T) {
+	feedServer := &testFeedServer{}
+	feedServer.add("Honk", time.Now().Add(-time.Hour))
+
+	// Every common path holds its answer until all of them have been
+	// asked, which only happens if they're asked at once.
+	var mu sync.Mutex
+	asked := 0
+	all := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		asked++
+		if asked == len(commonFeedPaths) {
+			close(all)
+		}
+		mu.Unlock()
+
+		select {
+		case <-all:
+		case <-r.Context().Done():
+			return
+		}
+
+		if r.URL.Path != "/atom.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		feedServer.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	b := newTestBot(NewMemoryStores(), srv.Client())
+
+	page, err := url.Parse(srv.URL + "/blog")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	links := b.discoverFeeds(page, []byte(`<html><body>No link tags here</body></html>`))
+	if len(links) != 1 || links[0].String() != srv.URL+"/atom.xml" {
+		t.Fatalf("want the feed at /atom.xml, got %v", links)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -11,6 +13,21 @@ var (
 	ErrNotRSSFeed    = errors.New("not a valid feed")
 	ErrAlreadyExists = errors.New("already exists")
 	ErrEmptyFeed     = errors.New("empty feed")
+	ErrInvalidURL    = errors.New("invalid URL")
+	ErrNotOPML       = errors.New("not an OPML file")
+	ErrFeedTooLarge  = errors.New("feed too large")
+
+	// ErrCollectionNameTaken means another collection in the server
+	// already goes by that name.
+	ErrCollectionNameTaken = errors.New("collection name already in use")
+
+	// ErrImportLimit means a feed was skipped because the OPML file had
+	// more feeds than one import takes.
+	ErrImportLimit = errors.New("too many feeds to import at once")
+
+	// ErrImportTimeout means an import ran out of time before it got to
+	// a feed.
+	ErrImportTimeout = errors.New("import ran out of time")
 )
 
 type ErrHTTP struct {
@@ -20,3 +37,13 @@ type ErrHTTP struct {
 func (e *ErrHTTP) Error() string {
 	return strings.ToLower(http.StatusText(e.StatusCode))
 }
+
+// ErrMultipleFeeds means a web page leads to several feeds and the user
+// has to pick one of them.
+type ErrMultipleFeeds struct {
+	Links []*url.URL
+}
+
+func (e *ErrMultipleFeeds) Error() string {
+	return fmt.Sprintf("found %d feeds", len(e.Links))
+}
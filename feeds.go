@@ -5,14 +5,49 @@ import (
 	"errors"
 	"net/url"
 	"time"
-
-	"github.com/lib/pq"
 )
 
 type Feed struct {
 	ID       int64
 	Link     string
+	Title    string
 	NotUntil time.Time
+
+	// NotUntilReason names the cache rule that decided NotUntil.
+	NotUntilReason string
+
+	// ETag and LastModified are the cache validators from the most
+	// recent successful fetch. They are sent back on the next crawl
+	// so the server can reply with 304 Not Modified.
+	ETag         string
+	LastModified string
+
+	// ConsecutiveFailures counts fetches that have failed since the
+	// last success. Once it reaches the configured threshold the feed
+	// is Disabled and no longer crawled.
+	ConsecutiveFailures int
+	LastError           string
+	LastSuccessAt       time.Time
+	Disabled            bool
+}
+
+const feedColumns = `id, link, title, not_until, not_until_reason, etag, last_modified, consecutive_failures, last_error, last_success_at, disabled`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFeed(row rowScanner, f *Feed) error {
+	var lastSuccessAt sql.NullTime
+
+	err := row.Scan(&f.ID, &f.Link, &f.Title, &f.NotUntil, &f.NotUntilReason, &f.ETag, &f.LastModified, &f.ConsecutiveFailures, &f.LastError, &lastSuccessAt, &f.Disabled)
+	if err != nil {
+		return err
+	}
+
+	f.LastSuccessAt = lastSuccessAt.Time
+
+	return nil
 }
 
 type Feeds struct {
@@ -20,15 +55,13 @@ type Feeds struct {
 }
 
 func (f *Feeds) Create(link *url.URL, notUntil time.Time) (*Feed, error) {
-	stmt := `INSERT INTO feeds (link, not_until) VALUES ($1, $2) RETURNING id, link, not_until`
-	args := []any{link.String(), notUntil}
-
-	var (
-		created Feed
-		pqerr   *pq.Error
-	)
-	err := f.DB.QueryRow(stmt, args...).Scan(&created.ID, &created.Link, &created.NotUntil)
-	if errors.As(err, &pqerr) && pqerr.Code == uniqueViolation {
+	stmt := `INSERT INTO feeds (link, not_until) VALUES ($1, $2) RETURNING ` + feedColumns
+	args := []any{link.String(), notUntil.UTC()}
+
+	var created Feed
+
+	err := scanFeed(f.DB.QueryRow(stmt, args...), &created)
+	if isUniqueViolation(err) {
 		err = ErrAlreadyExists
 	}
 	if err != nil {
@@ -39,8 +72,8 @@ func (f *Feeds) Create(link *url.URL, notUntil time.Time) (*Feed, error) {
 }
 
 func (f *Feeds) ListReady(readyAfter time.Time) ([]Feed, error) {
-	stmt := `SELECT id, link, not_until FROM feeds WHERE not_until <= $1`
-	args := []any{readyAfter}
+	stmt := `SELECT ` + feedColumns + ` FROM feeds WHERE not_until <= $1 AND NOT disabled`
+	args := []any{readyAfter.UTC()}
 
 	rows, err := f.DB.Query(stmt, args...)
 	if err != nil {
@@ -53,7 +86,7 @@ func (f *Feeds) ListReady(readyAfter time.Time) ([]Feed, error) {
 	for rows.Next() {
 		var f Feed
 
-		err := rows.Scan(&f.ID, &f.Link, &f.NotUntil)
+		err := scanFeed(rows, &f)
 		if err != nil {
 			return nil, err
 		}
@@ -64,13 +97,30 @@ func (f *Feeds) ListReady(readyAfter time.Time) ([]Feed, error) {
 	return list, nil
 }
 
+func (f *Feeds) Get(id int64) (*Feed, error) {
+	stmt := `SELECT ` + feedColumns + ` FROM feeds WHERE id = $1`
+	args := []any{id}
+
+	var fetched Feed
+
+	err := scanFeed(f.DB.QueryRow(stmt, args...), &fetched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetched, nil
+}
+
 func (f *Feeds) GetByLink(link string) (*Feed, error) {
-	stmt := `SELECT id, link, not_until FROM feeds WHERE link = $1`
+	stmt := `SELECT ` + feedColumns + ` FROM feeds WHERE link = $1`
 	args := []any{link}
 
 	var fetched Feed
 
-	err := f.DB.QueryRow(stmt, args...).Scan(&fetched.ID, &fetched.Link, &fetched.NotUntil)
+	err := scanFeed(f.DB.QueryRow(stmt, args...), &fetched)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -82,8 +132,20 @@ func (f *Feeds) GetByLink(link string) (*Feed, error) {
 }
 
 func (f *Feeds) Update(feed *Feed) error {
-	stmt := `UPDATE feeds SET link = $1, not_until = $2 WHERE id = $3`
-	args := []any{feed.Link, feed.NotUntil, feed.ID}
+	stmt := `UPDATE feeds SET
+			link = $1,
+			not_until = $2,
+			not_until_reason = $3,
+			etag = $4,
+			last_modified = $5,
+			consecutive_failures = $6,
+			last_error = $7,
+			last_success_at = $8,
+			disabled = $9,
+			title = $10
+		WHERE id = $11`
+	lastSuccessAt := sql.NullTime{Time: feed.LastSuccessAt.UTC(), Valid: !feed.LastSuccessAt.IsZero()}
+	args := []any{feed.Link, feed.NotUntil.UTC(), feed.NotUntilReason, feed.ETag, feed.LastModified, feed.ConsecutiveFailures, feed.LastError, lastSuccessAt, feed.Disabled, feed.Title, feed.ID}
 
 	_, err := f.DB.Exec(stmt, args...)
 
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	filterFieldAny         = "any"
+	filterFieldTitle       = "title"
+	filterFieldDescription = "description"
+	filterFieldCategories  = "categories"
+	filterFieldAuthor      = "author"
+
+	filterModeInclude = "include"
+	filterModeExclude = "exclude"
+
+	filterKindKeyword = "keyword"
+	filterKindRegex   = "regex"
+
+	filterPatternLimit      = 200
+	filtersPerCollectionMax = 25
+)
+
+var (
+	ErrInvalidFilter  = errors.New("invalid filter")
+	ErrTooManyFilters = errors.New("too many filters")
+)
+
+// Filter decides whether an item on a collection's feeds is announced.
+// An item is announced when it matches at least one include filter (or
+// there are none) and matches no exclude filters.
+type Filter struct {
+	ID           int64
+	CollectionID int64
+	Field        string
+	Mode         string
+	Kind         string
+	Pattern      string
+}
+
+// Validate reports whether the filter can be compiled.
+func (f *Filter) Validate() error {
+	switch f.Field {
+	case filterFieldAny, filterFieldTitle, filterFieldDescription, filterFieldCategories, filterFieldAuthor:
+	default:
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, f.Field)
+	}
+
+	switch f.Mode {
+	case filterModeInclude, filterModeExclude:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidFilter, f.Mode)
+	}
+
+	if strings.TrimSpace(f.Pattern) == "" {
+		return fmt.Errorf("%w: empty pattern", ErrInvalidFilter)
+	}
+
+	if len(f.Pattern) > filterPatternLimit {
+		return fmt.Errorf("%w: pattern is longer than %d characters", ErrInvalidFilter, filterPatternLimit)
+	}
+
+	_, err := f.matcher()
+	return err
+}
+
+func (f *Filter) matcher() (func(string) bool, error) {
+	switch f.Kind {
+	case filterKindKeyword:
+		keyword := strings.ToLower(f.Pattern)
+		return func(s string) bool {
+			return strings.Contains(strings.ToLower(s), keyword)
+		}, nil
+	case filterKindRegex:
+		// Regular expressions ignore case like keywords do.
+		re, err := regexp.Compile("(?i)" + f.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidFilter, f.Kind)
+	}
+}
+
+func (f *Filter) String() string {
+	return fmt.Sprintf("%s %s %s %q", f.Mode, f.Field, f.Kind, f.Pattern)
+}
+
+type compiledFilter struct {
+	field string
+	match func(string) bool
+}
+
+func (c *compiledFilter) matches(art *Article) bool {
+	var values []string
+
+	switch c.field {
+	case filterFieldTitle:
+		values = []string{art.Title}
+	case filterFieldDescription:
+		values = []string{art.Description}
+	case filterFieldAuthor:
+		values = []string{art.Author}
+	case filterFieldCategories:
+		values = art.Categories
+	default:
+		values = append([]string{art.Title, art.Description, art.Author}, art.Categories...)
+	}
+
+	for _, v := range values {
+		if c.match(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterSet is the compiled form of a subscription's filters.
+type FilterSet struct {
+	include []compiledFilter
+	exclude []compiledFilter
+}
+
+func NewFilterSet(filters []Filter) (*FilterSet, error) {
+	fs := &FilterSet{}
+
+	for _, f := range filters {
+		match, err := f.matcher()
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %w", f.ID, err)
+		}
+
+		c := compiledFilter{field: f.Field, match: match}
+		if f.Mode == filterModeExclude {
+			fs.exclude = append(fs.exclude, c)
+		} else {
+			fs.include = append(fs.include, c)
+		}
+	}
+
+	return fs, nil
+}
+
+// Allows reports whether art passes the filters.
+func (fs *FilterSet) Allows(art *Article) bool {
+	for _, c := range fs.exclude {
+		if c.matches(art) {
+			return false
+		}
+	}
+
+	if len(fs.include) == 0 {
+		return true
+	}
+
+	for _, c := range fs.include {
+		if c.matches(art) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Filters struct {
+	db *sql.DB
+}
+
+const filterColumns = `id, collection_id, field, mode, kind, pattern`
+
+func scanFilter(row rowScanner, f *Filter) error {
+	return row.Scan(&f.ID, &f.CollectionID, &f.Field, &f.Mode, &f.Kind, &f.Pattern)
+}
+
+func (fs *Filters) Create(filter Filter) (*Filter, error) {
+	stmt := `INSERT INTO filters (collection_id, field, mode, kind, pattern) VALUES ($1, $2, $3, $4, $5) RETURNING ` + filterColumns
+	args := []any{filter.CollectionID, filter.Field, filter.Mode, filter.Kind, filter.Pattern}
+
+	var created Filter
+
+	err := scanFilter(fs.db.QueryRow(stmt, args...), &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (fs *Filters) ListByCollection(collectionID int64) ([]Filter, error) {
+	stmt := `SELECT ` + filterColumns + ` FROM filters WHERE collection_id = $1 ORDER BY id ASC`
+	args := []any{collectionID}
+
+	rows, err := fs.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Filter
+	for rows.Next() {
+		var f Filter
+		if err := scanFilter(rows, &f); err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+
+	return list, nil
+}
+
+// Delete removes the filter with the given ID from a collection. It
+// returns ErrNotFound if the collection has no such filter.
+func (fs *Filters) Delete(collectionID, id int64) error {
+	stmt := `DELETE FROM filters WHERE collection_id = $1 AND id = $2`
+	args := []any{collectionID, id}
+
+	res, err := fs.db.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{name: "keyword", filter: Filter{Field: filterFieldTitle, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "release"}},
+		{name: "regex", filter: Filter{Field: filterFieldAny, Mode: filterModeExclude, Kind: filterKindRegex, Pattern: `v\d+\.\d+\.\d+-rc`}},
+		{name: "bad regex", filter: Filter{Field: filterFieldAny, Mode: filterModeExclude, Kind: filterKindRegex, Pattern: `(`}, wantErr: true},
+		{name: "empty pattern", filter: Filter{Field: filterFieldAny, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "  "}, wantErr: true},
+		{name: "unknown field", filter: Filter{Field: "link", Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "x"}, wantErr: true},
+		{name: "unknown mode", filter: Filter{Field: filterFieldAny, Mode: "maybe", Kind: filterKindKeyword, Pattern: "x"}, wantErr: true},
+		{name: "unknown kind", filter: Filter{Field: filterFieldAny, Mode: filterModeInclude, Kind: "glob", Pattern: "x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("want err=%v, got err=%v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("want err=%v, got err=%v", ErrInvalidFilter, err)
+			}
+		})
+	}
+}
+
+func TestFilterSetAllows(t *testing.T) {
+	release := &Article{
+		Title:      "goose v1.2.0",
+		Author:     "connorkuehl",
+		Categories: []string{"Releases"},
+	}
+	candidate := &Article{
+		Title:       "goose v1.3.0-rc1",
+		Description: "Release candidate, please test.",
+		Categories:  []string{"Pre-releases"},
+	}
+	cve := &Article{
+		Title:       "CVE-2023-1234",
+		Description: "Heap overflow in libhonk",
+		Categories:  []string{"Critical"},
+	}
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    map[*Article]bool
+	}{
+		{
+			name: "no filters",
+			want: map[*Article]bool{release: true, candidate: true, cve: true},
+		},
+		{
+			name: "include keyword in title",
+			filters: []Filter{
+				{Field: filterFieldTitle, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "GOOSE"},
+			},
+			want: map[*Article]bool{release: true, candidate: true, cve: false},
+		},
+		{
+			name: "exclude regex wins over include",
+			filters: []Filter{
+				{Field: filterFieldTitle, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "goose"},
+				{Field: filterFieldTitle, Mode: filterModeExclude, Kind: filterKindRegex, Pattern: `-rc\d+$`},
+			},
+			want: map[*Article]bool{release: true, candidate: false, cve: false},
+		},
+		{
+			name: "any of several includes",
+			filters: []Filter{
+				{Field: filterFieldCategories, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "critical"},
+				{Field: filterFieldAuthor, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "connor"},
+			},
+			want: map[*Article]bool{release: true, candidate: false, cve: true},
+		},
+		{
+			name: "exclude on any field",
+			filters: []Filter{
+				{Field: filterFieldAny, Mode: filterModeExclude, Kind: filterKindKeyword, Pattern: "release"},
+			},
+			want: map[*Article]bool{release: false, candidate: false, cve: true},
+		},
+		{
+			name: "description regex",
+			filters: []Filter{
+				{Field: filterFieldDescription, Mode: filterModeInclude, Kind: filterKindRegex, Pattern: `(?i)overflow`},
+			},
+			want: map[*Article]bool{release: false, candidate: false, cve: true},
+		},
+		{
+			name: "mixed-case regex",
+			filters: []Filter{
+				{Field: filterFieldTitle, Mode: filterModeInclude, Kind: filterKindRegex, Pattern: `^Goose V\d`},
+			},
+			want: map[*Article]bool{release: true, candidate: true, cve: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := NewFilterSet(tt.filters)
+			if err != nil {
+				t.Fatalf("NewFilterSet: %v", err)
+			}
+
+			for art, want := range tt.want {
+				if got := fs.Allows(art); got != want {
+					t.Errorf("want Allows(%q)=%v, got %v", art.Title, want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	defaultDisableAfterFailures = 10
+
+	baseFailureBackoff = 15 * time.Minute
+	maxFailureBackoff  = 7 * 24 * time.Hour
+)
+
+// failureBackoff returns how long to wait before fetching a feed that
+// has failed n times in a row. The wait doubles with every failure.
+func failureBackoff(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+
+	backoff := baseFailureBackoff
+	for i := 1; i < n; i++ {
+		backoff *= 2
+		if backoff >= maxFailureBackoff {
+			return maxFailureBackoff
+		}
+	}
+
+	return backoff
+}
+
+// recordFeedSuccess clears the failure state of a feed that was just
+// fetched successfully.
+func recordFeedSuccess(feed *Feed, now time.Time) {
+	feed.ConsecutiveFailures = 0
+	feed.LastError = ""
+	feed.LastSuccessAt = now
+}
+
+// recordFeedFailure backs the feed off after a failed fetch and reports
+// whether this failure crossed the threshold and disabled it.
+func (b *Bot) recordFeedFailure(feed *Feed, cause error, now time.Time) bool {
+	feed.ConsecutiveFailures++
+	feed.LastError = cause.Error()
+
+	// A Retry-After from the server may already ask for a longer wait
+	// than the backoff does.
+	retryAt := now.Add(failureBackoff(feed.ConsecutiveFailures))
+	if retryAt.After(feed.NotUntil) {
+		feed.NotUntil = retryAt
+		feed.NotUntilReason = cacheReasonFailureBackoff
+	}
+
+	if feed.Disabled || b.disableAfterFailures <= 0 || feed.ConsecutiveFailures < b.disableAfterFailures {
+		return false
+	}
+
+	feed.Disabled = true
+
+	return true
+}
+
+// notifyFeedDisabled tells every collection with feed in it that goose
+// has stopped crawling it.
+func (b *Bot) notifyFeedDisabled(ctx context.Context, feed *Feed) error {
+	subs, err := b.subscriptions.ListByFeed(feed.ID)
+	if err != nil {
+		return fmt.Errorf("list subscriptions: %w", err)
+	}
+
+	for _, sub := range subs {
+		logger := slog.With(
+			slog.Int64("subscription_id", sub.ID),
+			slog.Int64("collection_id", sub.CollectionID),
+			slog.Int64("feed_id", feed.ID),
+		)
+
+		c, err := b.collections.Get(sub.CollectionID)
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("get collection")
+			continue
+		}
+
+		logger = logger.With(
+			slog.String("guild_id", c.ServerID),
+			slog.String("channel_id", c.ChannelID),
+		)
+
+		err = b.rateLimiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		message := fmt.Sprintf("🪿 worried honk. The feed %s in the %q collection failed %d times in a row so I've stopped checking it. The last error was: %s. Use /subscribe with the same URL to start it up again.",
+			feed.Link, c.Name, feed.ConsecutiveFailures, feed.LastError)
+		_, err = b.send(c.ServerID, c.ChannelID, c.ChannelType, false, "Feed disabled: "+c.Name, &discordgo.MessageSend{Content: message})
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("send feed disabled message to channel")
+			continue
+		}
+	}
+
+	return nil
+}
+
+// feedHealth summarizes whether goose is still able to crawl feed.
+func feedHealth(feed *Feed) string {
+	switch {
+	case feed.Disabled:
+		return fmt.Sprintf("⛔ disabled after %d failures: %s", feed.ConsecutiveFailures, feed.LastError)
+	case feed.ConsecutiveFailures > 0:
+		return fmt.Sprintf("⚠️ failing (%d in a row): %s", feed.ConsecutiveFailures, feed.LastError)
+	default:
+		return "✅ healthy"
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFailureBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 1, want: 15 * time.Minute},
+		{failures: 2, want: 30 * time.Minute},
+		{failures: 3, want: time.Hour},
+		{failures: 6, want: 8 * time.Hour},
+		{failures: 10, want: 128 * time.Hour},
+		{failures: 11, want: maxFailureBackoff},
+		{failures: 1000, want: maxFailureBackoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failures), func(t *testing.T) {
+			got := failureBackoff(tt.failures)
+			if got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecordFeedFailure(t *testing.T) {
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	b := &Bot{disableAfterFailures: 3}
+
+	feed := &Feed{NotUntil: now.Add(time.Hour), NotUntilReason: cacheReasonRetryAfter}
+
+	// The server asked for a longer wait than the first backoff step.
+	if disabled := b.recordFeedFailure(feed, &ErrHTTP{StatusCode: 503}, now); disabled {
+		t.Fatalf("feed disabled after one failure")
+	}
+	if feed.NotUntilReason != cacheReasonRetryAfter || !feed.NotUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("want Retry-After to be kept, got NotUntil=%v reason=%q", feed.NotUntil, feed.NotUntilReason)
+	}
+	if feed.LastError != "service unavailable" {
+		t.Fatalf("want LastError=%q, got LastError=%q", "service unavailable", feed.LastError)
+	}
+
+	if disabled := b.recordFeedFailure(feed, &ErrHTTP{StatusCode: 500}, now); disabled {
+		t.Fatalf("feed disabled after two failures")
+	}
+
+	feed.NotUntil, feed.NotUntilReason = now, cacheReasonNoStore
+	if disabled := b.recordFeedFailure(feed, &ErrHTTP{StatusCode: 500}, now); !disabled {
+		t.Fatalf("feed not disabled after reaching the threshold")
+	}
+	if !feed.Disabled {
+		t.Fatalf("want Disabled=true")
+	}
+	if feed.NotUntilReason != cacheReasonFailureBackoff || !feed.NotUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("want backoff of 1h, got NotUntil=%v reason=%q", feed.NotUntil, feed.NotUntilReason)
+	}
+
+	// Further failures don't report the feed as newly disabled.
+	if disabled := b.recordFeedFailure(feed, &ErrHTTP{StatusCode: 500}, now); disabled {
+		t.Fatalf("feed reported as disabled twice")
+	}
+
+	recordFeedSuccess(feed, now)
+	if feed.ConsecutiveFailures != 0 || feed.LastError != "" || !feed.LastSuccessAt.Equal(now) {
+		t.Fatalf("want failure state cleared, got [%+v]", *feed)
+	}
+}
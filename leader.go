@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	leaderLease = "leader"
+
+	defaultLeaderLease = 30 * time.Second
+)
+
+// Elector decides which of several goose processes runs the crawler and
+// announcer. Whoever holds the leader lease is the leader, and it keeps
+// the lease by renewing it well before it expires. If the leader dies, the
+// lease runs out and another process takes over.
+type Elector struct {
+	Leases LeaseStore
+	Holder string
+	TTL    time.Duration
+
+	leader atomic.Bool
+}
+
+// IsLeader reports whether this process held the leader lease the last
+// time it tried to renew it.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for the leader lease until ctx is done, then releases it
+// so another process can take over right away.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign()
+
+		select {
+		case <-ctx.Done():
+			if e.leader.Swap(false) {
+				err := e.Leases.Release(leaderLease, e.Holder)
+				if err != nil {
+					slog.With(slog.Any("err", err)).Warn("release leader lease")
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) campaign() {
+	logger := slog.With(slog.String("holder", e.Holder))
+
+	leader, err := e.Leases.Acquire(leaderLease, e.Holder, e.TTL)
+	if err != nil {
+		// Without a renewed lease another process may take over soon, so
+		// stop leading rather than risk two leaders.
+		logger.With(slog.Any("err", err)).Error("acquire leader lease")
+		leader = false
+	}
+
+	was := e.leader.Swap(leader)
+	switch {
+	case leader && !was:
+		logger.Info("Became leader")
+	case !leader && was:
+		logger.Info("No longer leader")
+	}
+}
+
+// leaseHolder returns a name for this process that's unique among goose
+// replicas.
+func leaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "goose"
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestElectorFailover(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+	leases := NewMemoryStores().Leases
+
+	a := &Elector{Leases: leases, Holder: "a", TTL: ttl}
+	b := &Elector{Leases: leases, Holder: "b", TTL: ttl}
+
+	a.campaign()
+	b.campaign()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("want a to lead, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// a keeps renewing, so b never gets a turn.
+	for i := 0; i < 3; i++ {
+		time.Sleep(ttl / 3)
+		a.campaign()
+		b.campaign()
+		if !a.IsLeader() || b.IsLeader() {
+			t.Fatalf("want a to keep leading, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+		}
+	}
+
+	// a stops renewing and b takes over once the lease runs out.
+	b.campaign()
+	if b.IsLeader() {
+		t.Fatalf("want b to wait for the lease to expire")
+	}
+
+	time.Sleep(ttl + ttl/4)
+
+	b.campaign()
+	if !b.IsLeader() {
+		t.Fatalf("want b to lead after a's lease expired")
+	}
+
+	a.campaign()
+	if a.IsLeader() {
+		t.Fatalf("want a to step down once b has the lease")
+	}
+}
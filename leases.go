@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Leases are named locks that expire unless they're renewed, so a lock
+// held by a process that died is eventually free for another to take.
+type Leases struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// Acquire takes or renews the lease called name for holder for ttl. It
+// reports false if someone else holds an unexpired lease. Expiry is
+// decided by the database's clock rather than the callers', so replicas
+// whose clocks disagree can't both think they hold the lease.
+func (l *Leases) Acquire(name, holder string, ttl time.Duration) (bool, error) {
+	stmt := `INSERT INTO leases (name, holder, expires_at) VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at <= NOW()
+		RETURNING holder`
+	args := []any{name, holder, ttl.Seconds()}
+
+	// CURRENT_TIMESTAMP only has whole seconds in SQLite. Lease times are
+	// only ever written and compared here, so they're kept in strftime's
+	// format with milliseconds, which sorts correctly as text.
+	if l.dialect == dialectSQLite {
+		stmt = `INSERT INTO leases (name, holder, expires_at) VALUES ($1, $2, strftime('%Y-%m-%d %H:%M:%f', 'now', $3))
+			ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+			WHERE leases.holder = EXCLUDED.holder OR leases.expires_at <= strftime('%Y-%m-%d %H:%M:%f', 'now')
+			RETURNING holder`
+		args = []any{name, holder, fmt.Sprintf("%+.3f seconds", ttl.Seconds())}
+	}
+
+	var got string
+
+	err := l.db.QueryRow(stmt, args...).Scan(&got)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return got == holder, nil
+}
+
+// Release gives up the lease called name if holder has it.
+func (l *Leases) Release(name, holder string) error {
+	stmt := `DELETE FROM leases WHERE name = $1 AND holder = $2`
+	args := []any{name, holder}
+
+	_, err := l.db.Exec(stmt, args...)
+
+	return err
+}
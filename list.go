@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	listTitle      = "🪿 Subscriptions"
+	listPageSize   = 10
+	listPagePrefix = "list_page:"
+
+	// The footer only holds page and collection counts, which are well
+	// within this.
+	listFooterReserve = 64
+
+	embedFieldValueLimit = 1024
+
+	// Discord rejects embeds whose text adds up to more than this.
+	embedTotalLimit = 6000
+)
+
+type listEntry struct {
+	Collection Collection
+	Feeds      []listFeed
+}
+
+type listFeed struct {
+	Subscription Subscription
+	Feed         *Feed
+}
+
+// listing is a single page of a server's collections.
+type listing struct {
+	Entries []listEntry
+	Page    int
+	Pages   int
+	Total   int
+}
+
+// List responds with the first page of the server's collections.
+func (b *Bot) List(s *discordgo.Session, i *discordgo.Interaction) {
+	b.respondWithListPage(s, i, 0, discordgo.InteractionResponseChannelMessageWithSource)
+}
+
+// ListPage handles the pagination buttons on a /list response by
+// replacing the message with the requested page.
+func (b *Bot) ListPage(s *discordgo.Session, i *discordgo.Interaction) {
+	page, err := strconv.Atoi(strings.TrimPrefix(i.MessageComponentData().CustomID, listPagePrefix))
+	if err != nil {
+		page = 0
+	}
+
+	b.respondWithListPage(s, i, page, discordgo.InteractionResponseUpdateMessage)
+}
+
+func (b *Bot) respondWithListPage(s *discordgo.Session, i *discordgo.Interaction, page int, responseType discordgo.InteractionResponseType) {
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+		slog.Int("page", page),
+	)
+
+	l, err := b.listPage(i.GuildID, page)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("list subscriptions")
+		b.respondInternalError(s, i)
+		return
+	}
+
+	data := &discordgo.InteractionResponseData{}
+	if l.Total == 0 {
+		data.Content = "🪿 lonely honk. This server isn't subscribed to any feeds yet."
+	} else {
+		embed, components := renderListPage(l)
+		data.Embeds = []*discordgo.MessageEmbed{embed}
+		data.Components = components
+	}
+
+	err = s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: responseType,
+		Data: data,
+	})
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("respond to interaction")
+		return
+	}
+}
+
+// listPage loads one page of the server's collections along with their
+// feeds. The page is clamped to the range of pages that exist.
+func (b *Bot) listPage(serverID string, page int) (*listing, error) {
+	collections, err := b.collections.ListByServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// How many collections fit on a page depends on how many feeds they
+	// have, so every page has to be laid out to find the requested one.
+	entries := make([]listEntry, 0, len(collections))
+	for _, c := range collections {
+		subs, err := b.subscriptions.ListByCollection(c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list subscriptions of collection %d: %w", c.ID, err)
+		}
+
+		entry := listEntry{Collection: c}
+		for _, sub := range subs {
+			feed, err := b.feeds.Get(sub.FeedID)
+			if err != nil {
+				return nil, fmt.Errorf("get feed %d: %w", sub.FeedID, err)
+			}
+
+			entry.Feeds = append(entry.Feeds, listFeed{Subscription: sub, Feed: feed})
+		}
+
+		entries = append(entries, entry)
+	}
+
+	pages := paginateList(entries)
+
+	l := &listing{
+		Total: len(collections),
+		Pages: len(pages),
+		Page:  page,
+	}
+
+	if l.Page >= l.Pages {
+		l.Page = l.Pages - 1
+	}
+	if l.Page < 0 {
+		l.Page = 0
+	}
+
+	if l.Pages > 0 {
+		l.Entries = pages[l.Page]
+	}
+
+	return l, nil
+}
+
+// paginateList splits entries into pages that each render to an embed
+// Discord accepts.
+func paginateList(entries []listEntry) [][]listEntry {
+	budget := embedTotalLimit - len(listTitle) - listFooterReserve
+
+	var (
+		pages [][]listEntry
+		page  []listEntry
+		size  int
+	)
+
+	for _, entry := range entries {
+		field := listField(entry)
+		n := len(field.Name) + len(field.Value)
+
+		if len(page) > 0 && (len(page) == listPageSize || size+n > budget) {
+			pages = append(pages, page)
+			page, size = nil, 0
+		}
+
+		page = append(page, entry)
+		size += n
+	}
+
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+
+	return pages
+}
+
+// listField describes a collection and as many of its feeds as fit in
+// an embed field.
+func listField(entry listEntry) *discordgo.MessageEmbedField {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Announced to <#%s>", entry.Collection.ChannelID)
+
+	for n, f := range entry.Feeds {
+		lastAnnounced := "never"
+		if !f.Subscription.LastPubDate.IsZero() {
+			lastAnnounced = fmt.Sprintf("<t:%d:R>", f.Subscription.LastPubDate.Unix())
+		}
+
+		block := fmt.Sprintf("\n\n%s\nLast announced item: %s\nHealth: %s",
+			f.Feed.Link,
+			lastAnnounced,
+			feedHealth(f.Feed),
+		)
+
+		more := ""
+		if n < len(entry.Feeds)-1 {
+			more = fmt.Sprintf("\n\n…and %d more feeds.", len(entry.Feeds)-n-1)
+		}
+
+		if sb.Len()+len(block)+len(more) > embedFieldValueLimit {
+			fmt.Fprintf(&sb, "\n\n…and %d more feeds.", len(entry.Feeds)-n)
+			break
+		}
+		sb.WriteString(block)
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:  truncate(entry.Collection.Name, embedTitleLimit),
+		Value: truncate(sb.String(), embedFieldValueLimit),
+	}
+}
+
+func renderListPage(l *listing) (*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	embed := &discordgo.MessageEmbed{
+		Title: listTitle,
+		Color: embedColor,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d of %d (%d collections)", l.Page+1, l.Pages, l.Total),
+		},
+	}
+
+	for _, entry := range l.Entries {
+		embed.Fields = append(embed.Fields, listField(entry))
+	}
+
+	if l.Pages <= 1 {
+		return embed, nil
+	}
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "◀ Previous",
+					Style:    discordgo.SecondaryButton,
+					Disabled: l.Page == 0,
+					CustomID: listPagePrefix + strconv.Itoa(l.Page-1),
+				},
+				discordgo.Button{
+					Label:    "Next ▶",
+					Style:    discordgo.SecondaryButton,
+					Disabled: l.Page >= l.Pages-1,
+					CustomID: listPagePrefix + strconv.Itoa(l.Page+1),
+				},
+			},
+		},
+	}
+
+	return embed, components
+}
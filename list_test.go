@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRenderListPage(t *testing.T) {
+	l := &listing{
+		Entries: []listEntry{
+			{
+				Collection: Collection{Name: "Go Blog", ChannelID: "123"},
+				Feeds: []listFeed{
+					{
+						Subscription: Subscription{LastPubDate: time.Unix(1690000000, 0)},
+						Feed:         &Feed{Link: "https://go.dev/blog/feed.atom"},
+					},
+				},
+			},
+			{
+				Collection: Collection{Name: "Mixed", ChannelID: "456"},
+				Feeds: []listFeed{
+					{
+						Subscription: Subscription{LastPubDate: time.Unix(1690000000, 0)},
+						Feed:         &Feed{Link: "https://example.com/atom.xml"},
+					},
+					{
+						Feed: &Feed{Link: "https://example.com/rss", ConsecutiveFailures: 10, LastError: "not found", Disabled: true},
+					},
+				},
+			},
+		},
+		Page:  1,
+		Pages: 3,
+		Total: 22,
+	}
+
+	embed, components := renderListPage(l)
+
+	if len(embed.Fields) != 2 {
+		t.Fatalf("want 2 fields, got %d", len(embed.Fields))
+	}
+
+	for _, want := range []string{"https://go.dev/blog/feed.atom", "<#123>", "<t:1690000000:R>", "healthy"} {
+		if !strings.Contains(embed.Fields[0].Value, want) {
+			t.Errorf("want first field to contain %q, got %q", want, embed.Fields[0].Value)
+		}
+	}
+
+	for _, want := range []string{"<#456>", "https://example.com/atom.xml", "https://example.com/rss", "never", "disabled", "not found"} {
+		if !strings.Contains(embed.Fields[1].Value, want) {
+			t.Errorf("want second field to contain %q, got %q", want, embed.Fields[1].Value)
+		}
+	}
+
+	if want := "Page 2 of 3 (22 collections)"; embed.Footer.Text != want {
+		t.Errorf("want footer %q, got %q", want, embed.Footer.Text)
+	}
+
+	if len(components) != 1 {
+		t.Fatalf("want one row of buttons, got %d", len(components))
+	}
+
+	buttons := components[0].(discordgo.ActionsRow).Components
+	prev, next := buttons[0].(discordgo.Button), buttons[1].(discordgo.Button)
+
+	if prev.CustomID != listPagePrefix+"0" || prev.Disabled {
+		t.Errorf("want enabled previous button to page 0, got [%+v]", prev)
+	}
+	if next.CustomID != listPagePrefix+"2" || next.Disabled {
+		t.Errorf("want enabled next button to page 2, got [%+v]", next)
+	}
+
+	l.Page = 2
+	_, components = renderListPage(l)
+	next = components[0].(discordgo.ActionsRow).Components[1].(discordgo.Button)
+	if !next.Disabled {
+		t.Errorf("want next button disabled on the last page")
+	}
+
+	l.Pages = 1
+	if _, components = renderListPage(l); components != nil {
+		t.Errorf("want no buttons for a single page, got [%+v]", components)
+	}
+}
+
+func TestPaginateList(t *testing.T) {
+	small := make([]listEntry, 25)
+	for n := range small {
+		small[n] = listEntry{
+			Collection: Collection{Name: fmt.Sprintf("honk %d", n), ChannelID: "123"},
+			Feeds:      []listFeed{{Feed: &Feed{Link: "https://example.com/feed.xml"}}},
+		}
+	}
+
+	pages := paginateList(small)
+	if len(pages) != 3 || len(pages[0]) != listPageSize || len(pages[2]) != 5 {
+		t.Fatalf("want pages of %d, %d and 5 collections, got %d pages", listPageSize, listPageSize, len(pages))
+	}
+
+	big := make([]listEntry, listPageSize)
+	for n := range big {
+		big[n] = listEntry{Collection: Collection{Name: strings.Repeat("honk", 60), ChannelID: "123"}}
+		for m := 0; m < 30; m++ {
+			link := fmt.Sprintf("https://example.com/%s/%d.xml", strings.Repeat("goose", 10), m)
+			big[n].Feeds = append(big[n].Feeds, listFeed{Feed: &Feed{Link: link}})
+		}
+	}
+
+	pages = paginateList(big)
+	if len(pages) < 2 {
+		t.Fatalf("want collections with many feeds spread over several pages, got %d", len(pages))
+	}
+
+	var total int
+	for _, page := range pages {
+		total += len(page)
+
+		embed, _ := renderListPage(&listing{Entries: page, Page: 0, Pages: len(pages), Total: len(big)})
+
+		size := len(embed.Title) + len(embed.Footer.Text)
+		for _, field := range embed.Fields {
+			size += len(field.Name) + len(field.Value)
+
+			if len(field.Value) > embedFieldValueLimit {
+				t.Errorf("want field value of at most %d characters, got %d", embedFieldValueLimit, len(field.Value))
+			}
+			if !strings.HasSuffix(field.Value, "more feeds.") {
+				t.Errorf("want feeds that don't fit counted, got %q", field.Value)
+			}
+		}
+
+		if size > embedTotalLimit {
+			t.Errorf("want embed of at most %d characters, got %d", embedTotalLimit, size)
+		}
+	}
+
+	if total != len(big) {
+		t.Errorf("want every collection on a page, got %d of %d", total, len(big))
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -40,15 +40,45 @@ func main() {
 func run(ctx context.Context) error {
 	var (
 		discordToken              string
+		databaseDSN               string
 		postgresDSN               string
 		crawlerDelayIntervalSecs  int
 		announceDelayIntervalSecs int
+		cacheFloorSecs            int
+		cacheCeilingSecs          int
+		disableAfterFailures      int
+		crawlerWorkers            int
+		crawlerPerHost            int
+		crawlerHostDelaySecs      int
+		crawlerTimeoutSecs        int
+		shardCount                int
+		shardIDs                  string
+		leaderLeaseSecs           int
+		lookbackSecs              int
+		migrateOnStart            bool
 	)
 
 	flag.StringVar(&discordToken, "discord-token", "", "Discord Bot token")
-	flag.StringVar(&postgresDSN, "postgres-dsn", "", "PostgreSQL DSN")
+	flag.StringVar(&databaseDSN, "database-dsn", "", "PostgreSQL DSN, or sqlite://path/to/goose.db for a SQLite database")
+	flag.StringVar(&postgresDSN, "postgres-dsn", "", "PostgreSQL DSN (deprecated, use -database-dsn)")
 	flag.IntVar(&crawlerDelayIntervalSecs, "crawler-interval-secs", 3600, "How long to wait (in seconds) before checking RSS feeds")
 	flag.IntVar(&announceDelayIntervalSecs, "announce-interval-secs", 300, "How long to wait (in seconds) before checking for new items to announce")
+	flag.IntVar(&cacheFloorSecs, "cache-floor-secs", int(defaultCacheFloor/time.Second), "Minimum time (in seconds) to wait before fetching a feed again, regardless of its caching headers")
+	flag.IntVar(&cacheCeilingSecs, "cache-ceiling-secs", int(defaultCacheCeiling/time.Second), "Maximum time (in seconds) to wait before fetching a feed again, regardless of its caching headers")
+	flag.IntVar(&disableAfterFailures, "disable-after-failures", defaultDisableAfterFailures, "How many consecutive failed fetches before a feed is disabled (0 never disables)")
+	flag.IntVar(&crawlerWorkers, "crawler-workers", defaultCrawlerWorkers, "How many feeds to fetch concurrently")
+	flag.IntVar(&crawlerPerHost, "crawler-per-host", defaultCrawlerPerHost, "How many concurrent requests to allow to the same host")
+	flag.IntVar(&crawlerHostDelaySecs, "crawler-host-delay-secs", int(defaultCrawlerHostDelay/time.Second), "Minimum time (in seconds) between starting requests to the same host")
+	flag.IntVar(&crawlerTimeoutSecs, "crawler-timeout-secs", int(defaultCrawlerTimeout/time.Second), "How long to wait (in seconds) for a feed to respond during a crawl")
+	flag.IntVar(&shardCount, "shard-count", 1, "How many gateway shards the bot uses across all goose processes (0 uses Discord's recommendation)")
+	flag.StringVar(&shardIDs, "shard-ids", "", "Comma-separated shard IDs or ranges to run in this process, e.g. \"0-3,8\" (empty runs every shard)")
+	flag.IntVar(&leaderLeaseSecs, "leader-lease-secs", int(defaultLeaderLease/time.Second), "How long (in seconds) other goose processes wait for the leader to check in before one of them takes over crawling and announcing")
+	flag.IntVar(&lookbackSecs, "lookback-secs", int(defaultLookback/time.Second), "How old (in seconds) an item goose hasn't seen before can be and still be announced (0 announces every new item)")
+	flag.BoolVar(&migrateOnStart, "migrate-on-start", true, "Apply database migrations before starting")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s [flags]\n  %s [flags] migrate up|down [N]|status\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	discordToken = func(defaultValue string) string {
@@ -58,12 +88,18 @@ func run(ctx context.Context) error {
 		return defaultValue
 	}(discordToken)
 
-	postgresDSN = func(defaultValue string) string {
-		if value, ok := os.LookupEnv("GOOSE_POSTGRES_DSN"); ok {
+	databaseDSN = func(defaultValue string) string {
+		if value, ok := os.LookupEnv("GOOSE_DATABASE_DSN"); ok {
+			return value
+		}
+		if value, ok := os.LookupEnv("GOOSE_POSTGRES_DSN"); ok && defaultValue == "" {
 			return value
 		}
+		if defaultValue == "" {
+			return postgresDSN
+		}
 		return defaultValue
-	}(postgresDSN)
+	}(databaseDSN)
 
 	crawlerDelayIntervalSecs = func(defaultValue int) int {
 		if strvalue, ok := os.LookupEnv("GOOSE_CRAWLER_INTERVAL_SECS"); ok {
@@ -83,15 +119,132 @@ func run(ctx context.Context) error {
 		return defaultValue
 	}(announceDelayIntervalSecs)
 
+	cacheFloorSecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CACHE_FLOOR_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(cacheFloorSecs)
+
+	cacheCeilingSecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CACHE_CEILING_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(cacheCeilingSecs)
+
+	disableAfterFailures = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_DISABLE_AFTER_FAILURES"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(disableAfterFailures)
+
+	crawlerWorkers = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CRAWLER_WORKERS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(crawlerWorkers)
+
+	crawlerPerHost = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CRAWLER_PER_HOST"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(crawlerPerHost)
+
+	crawlerHostDelaySecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CRAWLER_HOST_DELAY_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(crawlerHostDelaySecs)
+
+	crawlerTimeoutSecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_CRAWLER_TIMEOUT_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(crawlerTimeoutSecs)
+
+	shardCount = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_SHARD_COUNT"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(shardCount)
+
+	shardIDs = func(defaultValue string) string {
+		if value, ok := os.LookupEnv("GOOSE_SHARD_IDS"); ok {
+			return value
+		}
+		return defaultValue
+	}(shardIDs)
+
+	leaderLeaseSecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_LEADER_LEASE_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(leaderLeaseSecs)
+
+	lookbackSecs = func(defaultValue int) int {
+		if strvalue, ok := os.LookupEnv("GOOSE_LOOKBACK_SECS"); ok {
+			if value, err := strconv.ParseInt(strvalue, 10, 64); err == nil {
+				return int(value)
+			}
+		}
+		return defaultValue
+	}(lookbackSecs)
+
+	migrateOnStart = func(defaultValue bool) bool {
+		if strvalue, ok := os.LookupEnv("GOOSE_MIGRATE_ON_START"); ok {
+			if value, err := strconv.ParseBool(strvalue); err == nil {
+				return value
+			}
+		}
+		return defaultValue
+	}(migrateOnStart)
+
+	if flag.Arg(0) == "migrate" {
+		if databaseDSN == "" {
+			return errors.New("missing required database DSN")
+		}
+		return runMigrate(ctx, databaseDSN, flag.Args()[1:])
+	}
+
 	if discordToken == "" {
 		return errors.New("missing required Discord token")
 	}
 
-	if postgresDSN == "" {
-		return errors.New("missing required PostgreSQL DSN")
+	if databaseDSN == "" {
+		return errors.New("missing required database DSN")
 	}
 
-	db, err := sql.Open("postgres", postgresDSN)
+	if leaderLeaseSecs <= 0 {
+		return fmt.Errorf("leader lease must be at least 1 second, got %d", leaderLeaseSecs)
+	}
+
+	db, dialect, err := OpenDatabase(databaseDSN)
 	if err != nil {
 		return err
 	}
@@ -110,49 +263,96 @@ func run(ctx context.Context) error {
 
 	slog.With(slog.Duration("duration", time.Since(connStartDB))).Info("Connected to database")
 
-	articles := &Articles{
-		db: db,
+	migrator, err := NewMigrator(db, dialect)
+	if err != nil {
+		return err
 	}
 
-	feeds := &Feeds{
-		DB: db,
+	if migrateOnStart {
+		err = migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
 	}
 
-	subscriptions := &Subscriptions{
-		db: db,
+	err = migrator.Check(ctx)
+	if err != nil {
+		return err
 	}
 
-	session, err := discordgo.New("Bot " + discordToken)
+	stores := NewStores(db, dialect)
+
+	ownShards, err := parseShardIDs(shardIDs)
+	if err != nil {
+		return err
+	}
+
+	shards, err := NewShards("Bot "+discordToken, shardCount, ownShards)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	defer shards.Close()
 
 	rateLimiter := rate.NewLimiter(rate.Every(time.Second), 1)
 
 	bot := &Bot{
-		articles:        articles,
-		feeds:           feeds,
-		subscriptions:   subscriptions,
-		autocompletions: &AutoCompletions{subscriptions: subscriptions},
+		articles:        stores.Articles,
+		feeds:           stores.Feeds,
+		collections:     stores.Collections,
+		subscriptions:   stores.Subscriptions,
+		filters:         stores.Filters,
+		deliveries:      stores.Deliveries,
+		autocompletions: &AutoCompletions{collections: stores.Collections},
 		rateLimiter:     rateLimiter,
-		session:         session,
+		shards:          shards,
 		httpClient: &http.Client{
 			Timeout: 3 * time.Second,
 		},
+		cachePolicy: &CachePolicy{
+			Default: defaultCache,
+			Floor:   time.Duration(cacheFloorSecs) * time.Second,
+			Ceiling: time.Duration(cacheCeilingSecs) * time.Second,
+		},
+		crawler: &Crawler{
+			Client: &http.Client{
+				Timeout: time.Duration(crawlerTimeoutSecs) * time.Second,
+			},
+			Workers:   crawlerWorkers,
+			PerHost:   crawlerPerHost,
+			HostDelay: time.Duration(crawlerHostDelaySecs) * time.Second,
+		},
+		disableAfterFailures: disableAfterFailures,
+		lookback:             time.Duration(lookbackSecs) * time.Second,
 	}
 
-	session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	shards.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type == discordgo.InteractionMessageComponent {
+			data := i.MessageComponentData()
+
+			switch {
+			case strings.HasPrefix(data.CustomID, listPagePrefix):
+				bot.ListPage(s, i.Interaction)
+			case strings.HasPrefix(data.CustomID, subscribePickPrefix):
+				bot.SubscribePick(s, i.Interaction)
+			}
+			return
+		}
+
 		data := i.ApplicationCommandData()
 
 		if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
-			for _, option := range data.Options {
+			options := data.Options
+			if len(options) == 1 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+				options = options[0].Options
+			}
+
+			for _, option := range options {
 				if !option.Focused || option.Name != optionCollectionName {
 					continue
 				}
 
 				switch data.Name {
-				case commandUnsubscribe, commandTest:
+				case commandSubscribe, commandUnsubscribe, commandTest, commandTemplate, commandEdit, commandFilter:
 					bot.AutocompleteCollectionName(s, i.Interaction, option)
 					return
 				default:
@@ -168,15 +368,28 @@ func run(ctx context.Context) error {
 			bot.Unsubscribe(s, i.Interaction)
 		case commandTest:
 			bot.Test(s, i.Interaction)
+		case commandTemplate:
+			bot.Template(s, i.Interaction)
+		case commandEdit:
+			bot.Edit(s, i.Interaction)
+		case commandList:
+			bot.List(s, i.Interaction)
+		case commandImport:
+			bot.Import(s, i.Interaction)
+		case commandExport:
+			bot.Export(s, i.Interaction)
+		case commandFilter:
+			bot.Filter(s, i.Interaction)
 		}
 	})
 
 	connStartDisc := time.Now()
-	err = session.Open()
+	err = shards.Open()
 	if err != nil {
 		return err
 	}
 
+	session := shards.Any()
 	for _, cmd := range commands {
 		_, err := session.ApplicationCommandCreate(session.State.User.ID, "", cmd)
 		if err != nil {
@@ -186,6 +399,27 @@ func run(ctx context.Context) error {
 
 	slog.With("duration", time.Since(connStartDisc)).Info("Connected to Discord")
 
+	// Every process answers interactions, but only the leader crawls
+	// feeds and announces new items.
+	elector := &Elector{
+		Leases: stores.Leases,
+		Holder: leaseHolder(),
+		TTL:    time.Duration(leaderLeaseSecs) * time.Second,
+	}
+
+	bot.isLeader = elector.IsLeader
+
+	electorCtx, stopElector := context.WithCancel(ctx)
+	electorDone := make(chan struct{})
+	go func() {
+		defer close(electorDone)
+		elector.Run(electorCtx)
+	}()
+	defer func() {
+		stopElector()
+		<-electorDone
+	}()
+
 	updateTicker := time.NewTicker(time.Duration(announceDelayIntervalSecs) * time.Second)
 	defer updateTicker.Stop()
 
@@ -197,9 +431,70 @@ func run(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-updateTicker.C:
-			_ = bot.Update(ctx)
+			if elector.IsLeader() {
+				_ = bot.Update(ctx)
+			}
 		case <-refreshTicker.C:
-			_ = bot.RefreshFeeds(ctx)
+			if elector.IsLeader() {
+				_ = bot.RefreshFeeds(ctx)
+			}
 		}
 	}
 }
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(ctx context.Context, dsn string, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return errors.New("missing migrate command")
+	}
+
+	db, dialect, err := OpenDatabase(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("bad number of migrations to revert: %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "status":
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrator.Migrations() {
+		state := "pending"
+		if m.Version <= version {
+			state = "applied"
+		}
+		fmt.Printf("%06d %-40s %s\n", m.Version, m.Name, state)
+	}
+
+	fmt.Printf("database version %d (dirty: %v), goose version %d\n", version, dirty, migrator.Latest())
+
+	return nil
+}
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// errForeignKey is returned by the in-memory stores wherever the
+// database would report a foreign key violation.
+var errForeignKey = errors.New("violates foreign key constraint")
+
+// Memory keeps everything goose tracks in memory. It behaves like the
+// database stores, so the bot can be tested without a database server.
+type Memory struct {
+	mu sync.Mutex
+
+	nextID        int64
+	feeds         map[int64]*Feed
+	articles      map[int64]*Article
+	collections   map[int64]*Collection
+	subscriptions map[int64]*Subscription
+	filters       map[int64]*Filter
+	deliveries    map[int64]*memoryDelivery
+	leases        map[string]*memoryLease
+}
+
+type memoryDelivery struct {
+	id             int64
+	subscriptionID int64
+	articleID      int64
+	status         string
+	attempts       int
+	notBefore      time.Time
+	lastError      string
+	channelID      string
+	messageID      string
+	update         bool
+}
+
+type memoryLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		feeds:         make(map[int64]*Feed),
+		articles:      make(map[int64]*Article),
+		collections:   make(map[int64]*Collection),
+		subscriptions: make(map[int64]*Subscription),
+		filters:       make(map[int64]*Filter),
+		deliveries:    make(map[int64]*memoryDelivery),
+		leases:        make(map[string]*memoryLease),
+	}
+}
+
+// NewMemoryStores returns stores that share a new Memory.
+func NewMemoryStores() Stores {
+	m := NewMemory()
+
+	return Stores{
+		Feeds:         memoryFeeds{m},
+		Articles:      memoryArticles{m},
+		Collections:   memoryCollections{m},
+		Subscriptions: memorySubscriptions{m},
+		Filters:       memoryFilters{m},
+		Deliveries:    memoryDeliveries{m},
+		Leases:        memoryLeases{m},
+	}
+}
+
+func (m *Memory) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+// sortedIDs returns the keys of a table in the order they were created.
+func sortedIDs[T any](table map[int64]T) []int64 {
+	ids := make([]int64, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func copyArticle(art *Article) Article {
+	c := *art
+	c.Categories = append([]string{}, art.Categories...)
+	return c
+}
+
+type memoryFeeds struct{ *Memory }
+
+func (m memoryFeeds) Create(link *url.URL, notUntil time.Time) (*Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.feeds {
+		if f.Link == link.String() {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	f := &Feed{ID: m.id(), Link: link.String(), NotUntil: notUntil}
+	m.feeds[f.ID] = f
+
+	created := *f
+	return &created, nil
+}
+
+func (m memoryFeeds) ListReady(readyAfter time.Time) ([]Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Feed
+	for _, id := range sortedIDs(m.feeds) {
+		f := m.feeds[id]
+		if !f.NotUntil.After(readyAfter) && !f.Disabled {
+			list = append(list, *f)
+		}
+	}
+
+	return list, nil
+}
+
+func (m memoryFeeds) Get(id int64) (*Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.feeds[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	fetched := *f
+	return &fetched, nil
+}
+
+func (m memoryFeeds) GetByLink(link string) (*Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.feeds {
+		if f.Link == link {
+			fetched := *f
+			return &fetched, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m memoryFeeds) Update(feed *Feed) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.feeds {
+		if f.Link == feed.Link && f.ID != feed.ID {
+			return ErrAlreadyExists
+		}
+	}
+
+	if _, ok := m.feeds[feed.ID]; ok {
+		updated := *feed
+		m.feeds[feed.ID] = &updated
+	}
+
+	return nil
+}
+
+func (m memoryFeeds) Delete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.articles {
+		if a.FeedID == id {
+			return errForeignKey
+		}
+	}
+	for _, s := range m.subscriptions {
+		if s.FeedID == id {
+			return errForeignKey
+		}
+	}
+
+	delete(m.feeds, id)
+
+	return nil
+}
+
+type memoryArticles struct{ *Memory }
+
+func (m memoryArticles) Create(article Article) (*Article, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.feeds[article.FeedID]; !ok {
+		return nil, errForeignKey
+	}
+
+	if article.GUID == "" {
+		article.GUID = normalizeURL(article.Link)
+	}
+
+	for _, a := range m.articles {
+		if a.FeedID == article.FeedID && a.GUID == article.GUID {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	now := time.Now().UTC()
+
+	art := copyArticle(&article)
+	art.ID = m.id()
+	if art.DateSource == "" {
+		art.DateSource = DateSourcePublished
+	}
+	if art.FirstSeen.IsZero() {
+		art.FirstSeen = now
+	}
+	art.FirstSeen = art.FirstSeen.UTC()
+	art.Updated = art.Updated.UTC()
+	m.articles[art.ID] = &art
+
+	for _, id := range sortedIDs(m.subscriptions) {
+		sub := m.subscriptions[id]
+		if sub.FeedID != art.FeedID {
+			continue
+		}
+
+		d := &memoryDelivery{
+			id:             m.id(),
+			subscriptionID: sub.ID,
+			articleID:      art.ID,
+			status:         deliveryPending,
+			notBefore:      now,
+		}
+		m.deliveries[d.id] = d
+	}
+
+	created := copyArticle(&art)
+	return &created, nil
+}
+
+func (m memoryArticles) Update(article Article) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if article.GUID == "" {
+		article.GUID = normalizeURL(article.Link)
+	}
+
+	var art *Article
+	for _, a := range m.articles {
+		if a.FeedID == article.FeedID && a.GUID == article.GUID {
+			art = a
+			break
+		}
+	}
+	if art == nil {
+		return false, ErrNotFound
+	}
+
+	if art.ContentHash == article.ContentHash {
+		return false, nil
+	}
+
+	changed := art.ContentHash != ""
+
+	art.Title = article.Title
+	art.Link = article.Link
+	art.Author = article.Author
+	art.Description = article.Description
+	art.ImageURL = article.ImageURL
+	art.Categories = append([]string{}, article.Categories...)
+	art.ContentHash = article.ContentHash
+	art.Updated = article.Updated.UTC()
+	art.MediaURL = article.MediaURL
+	art.MediaType = article.MediaType
+	art.Episode = article.Episode
+	art.Season = article.Season
+	art.Duration = article.Duration
+
+	if !changed {
+		return false, nil
+	}
+
+	for _, d := range m.deliveries {
+		if d.articleID != art.ID || d.status != deliverySent {
+			continue
+		}
+
+		sub := m.subscriptions[d.subscriptionID]
+		if m.collections[sub.CollectionID].UpdateMode == UpdateModeOff {
+			continue
+		}
+
+		d.status = deliveryPending
+		d.attempts = 0
+		d.notBefore = time.Now().UTC()
+		d.lastError = ""
+		d.update = true
+	}
+
+	return true, nil
+}
+
+func (m memoryArticles) Latest(feedID int64) (*Article, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *Article
+	for _, id := range sortedIDs(m.articles) {
+		a := m.articles[id]
+		if a.FeedID != feedID {
+			continue
+		}
+		if latest == nil || !a.Published.Before(latest.Published) {
+			latest = a
+		}
+	}
+
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+
+	art := copyArticle(latest)
+	return &art, nil
+}
+
+func (m memoryArticles) Hashes(feedID int64) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hashes := make(map[string]string)
+	for _, a := range m.articles {
+		if a.FeedID == feedID {
+			hashes[a.GUID] = a.ContentHash
+		}
+	}
+
+	return hashes, nil
+}
+
+type memoryCollections struct{ *Memory }
+
+func (m memoryCollections) Create(collection Collection) (*Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.collections {
+		if c.ServerID == collection.ServerID && c.Name == collection.Name {
+			return nil, ErrCollectionNameTaken
+		}
+	}
+
+	c := collection
+	c.ID = m.id()
+	if c.UpdateMode == "" {
+		c.UpdateMode = UpdateModeOff
+	}
+	m.collections[c.ID] = &c
+
+	created := c
+	return &created, nil
+}
+
+func (m memoryCollections) Get(id int64) (*Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.collections[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	fetched := *c
+	return &fetched, nil
+}
+
+func (m memoryCollections) GetByName(serverID, name string) (*Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.collections {
+		if c.ServerID == serverID && c.Name == name {
+			fetched := *c
+			return &fetched, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m memoryCollections) Names(serverID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for _, id := range sortedIDs(m.collections) {
+		c := m.collections[id]
+		if c.ServerID == serverID {
+			names = append(names, c.Name)
+		}
+	}
+
+	return names, nil
+}
+
+func (m memoryCollections) ListByServer(serverID string) ([]Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var collections []Collection
+	for _, id := range sortedIDs(m.collections) {
+		c := m.collections[id]
+		if c.ServerID == serverID {
+			collections = append(collections, *c)
+		}
+	}
+
+	sort.SliceStable(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	return collections, nil
+}
+
+func (m memoryCollections) UpdateTemplate(id int64, template string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.collections[id]; ok {
+		c.Template = template
+	}
+
+	return nil
+}
+
+func (m memoryCollections) UpdateChannel(id int64, channelID string, channelType discordgo.ChannelType, crosspost bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.collections[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	c.ChannelID = channelID
+	c.ChannelType = channelType
+	c.Crosspost = crosspost
+
+	return nil
+}
+
+func (m memoryCollections) SetUpdateMode(id int64, mode UpdateMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.collections[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	c.UpdateMode = mode
+
+	return nil
+}
+
+func (m memoryCollections) Rename(id int64, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	collection, ok := m.collections[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	for _, c := range m.collections {
+		if c.ID != id && c.ServerID == collection.ServerID && c.Name == name {
+			return ErrCollectionNameTaken
+		}
+	}
+
+	collection.Name = name
+
+	return nil
+}
+
+func (m memoryCollections) Delete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.collections, id)
+
+	for sid, s := range m.subscriptions {
+		if s.CollectionID == id {
+			m.deleteSubscription(sid)
+		}
+	}
+	for fid, f := range m.filters {
+		if f.CollectionID == id {
+			delete(m.filters, fid)
+		}
+	}
+
+	return nil
+}
+
+type memorySubscriptions struct{ *Memory }
+
+func (m memorySubscriptions) Create(subscription Subscription) (*Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.collections[subscription.CollectionID]; !ok {
+		return nil, errForeignKey
+	}
+	if _, ok := m.feeds[subscription.FeedID]; !ok {
+		return nil, errForeignKey
+	}
+
+	for _, s := range m.subscriptions {
+		if s.CollectionID == subscription.CollectionID && s.FeedID == subscription.FeedID {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	sub := subscription
+	sub.ID = m.id()
+	m.subscriptions[sub.ID] = &sub
+
+	created := sub
+	return &created, nil
+}
+
+func (m memorySubscriptions) UpdateLastPubDate(id int64, lastPubDate time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.subscriptions[id]; ok && s.LastPubDate.Before(lastPubDate) {
+		s.LastPubDate = lastPubDate
+	}
+
+	return nil
+}
+
+func (m memorySubscriptions) ListByCollection(collectionID int64) ([]Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var subs []Subscription
+	for _, id := range sortedIDs(m.subscriptions) {
+		s := m.subscriptions[id]
+		if s.CollectionID == collectionID {
+			subs = append(subs, *s)
+		}
+	}
+
+	return subs, nil
+}
+
+func (m memorySubscriptions) ListByFeed(feedID int64) ([]Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var subs []Subscription
+	for _, id := range sortedIDs(m.subscriptions) {
+		s := m.subscriptions[id]
+		if s.FeedID == feedID {
+			subs = append(subs, *s)
+		}
+	}
+
+	return subs, nil
+}
+
+func (m memorySubscriptions) Delete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteSubscription(id)
+
+	return nil
+}
+
+// deleteSubscription removes a subscription and its deliveries. m.mu must
+// be held.
+func (m *Memory) deleteSubscription(id int64) {
+	delete(m.subscriptions, id)
+
+	for did, d := range m.deliveries {
+		if d.subscriptionID == id {
+			delete(m.deliveries, did)
+		}
+	}
+}
+
+type memoryFilters struct{ *Memory }
+
+func (m memoryFilters) Create(filter Filter) (*Filter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.collections[filter.CollectionID]; !ok {
+		return nil, errForeignKey
+	}
+
+	f := filter
+	f.ID = m.id()
+	m.filters[f.ID] = &f
+
+	created := f
+	return &created, nil
+}
+
+func (m memoryFilters) ListByCollection(collectionID int64) ([]Filter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Filter
+	for _, id := range sortedIDs(m.filters) {
+		f := m.filters[id]
+		if f.CollectionID == collectionID {
+			list = append(list, *f)
+		}
+	}
+
+	return list, nil
+}
+
+func (m memoryFilters) Delete(collectionID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.filters[id]
+	if !ok || f.CollectionID != collectionID {
+		return ErrNotFound
+	}
+
+	delete(m.filters, id)
+
+	return nil
+}
+
+type memoryDeliveries struct{ *Memory }
+
+func (m memoryDeliveries) Claim(now time.Time, lease time.Duration, limit int) ([]Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*memoryDelivery
+	for _, id := range sortedIDs(m.deliveries) {
+		d := m.deliveries[id]
+		if d.status == deliveryPending && !d.notBefore.After(now) {
+			due = append(due, d)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return m.articles[due[i].articleID].Published.Before(m.articles[due[j].articleID].Published)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var notifications []Notification
+	for _, d := range due {
+		d.attempts++
+		d.notBefore = now.Add(lease)
+
+		sub := m.subscriptions[d.subscriptionID]
+		c := m.collections[sub.CollectionID]
+		art := m.articles[d.articleID]
+
+		notifications = append(notifications, Notification{
+			DeliveryID:     d.id,
+			Attempts:       d.attempts,
+			SubscriptionID: sub.ID,
+			CollectionID:   c.ID,
+			ServerID:       c.ServerID,
+			ChannelID:      c.ChannelID,
+			ChannelType:    c.ChannelType,
+			Crosspost:      c.Crosspost,
+			CollectionName: c.Name,
+			FeedTitle:      m.feeds[art.FeedID].Title,
+			Template:       c.Template,
+			Article:        copyArticle(art),
+			Update:         d.update,
+			MessageID:      d.messageID,
+			MessageChannel: d.channelID,
+			UpdateMode:     c.UpdateMode,
+		})
+	}
+
+	return notifications, nil
+}
+
+func (m memoryDeliveries) Sent(id int64, channelID, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[id]; ok {
+		d.status = deliverySent
+		d.channelID = channelID
+		d.messageID = messageID
+		d.lastError = ""
+	}
+
+	return nil
+}
+
+func (m memoryDeliveries) Skip(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[id]; ok {
+		d.status = deliverySkipped
+	}
+
+	return nil
+}
+
+func (m memoryDeliveries) Retry(id int64, cause error, notBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[id]; ok {
+		d.notBefore = notBefore
+		d.lastError = cause.Error()
+	}
+
+	return nil
+}
+
+func (m memoryDeliveries) Fail(id int64, cause error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[id]; ok {
+		d.status = deliveryFailed
+		d.lastError = cause.Error()
+	}
+
+	return nil
+}
+
+type memoryLeases struct{ *Memory }
+
+func (m memoryLeases) Acquire(name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	l, ok := m.leases[name]
+	if ok && l.holder != holder && l.expiresAt.After(now) {
+		return false, nil
+	}
+
+	m.leases[name] = &memoryLease{holder: holder, expiresAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+func (m memoryLeases) Release(name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.leases[name]; ok && l.holder == holder {
+		delete(m.leases, name)
+	}
+
+	return nil
+}
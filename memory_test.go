@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestMemoryStores(t *testing.T) {
+	testStores(t, func(t *testing.T) Stores {
+		return NewMemoryStores()
+	})
+}
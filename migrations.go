@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating,
+// so replicas starting at the same time take turns.
+const migrationLockID = 0x676f6f7365 // "goose"
+
+var (
+	ErrSchemaTooNew   = errors.New("database schema is newer than this version of goose")
+	ErrSchemaOutdated = errors.New("database schema is out of date")
+	ErrDirtySchema    = errors.New("database schema is dirty")
+)
+
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the migrations that are built into goose. It records
+// the schema version in the same schema_migrations table as the migrate
+// tool, so databases set up with it carry on from where they were.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, d dialect) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", d.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// loadMigrations reads NNNNNN_name.up.sql and NNNNNN_name.down.sql pairs
+// from dir, ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %q: missing version", name)
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: bad version: %w", name, err)
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d: missing up or down", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest returns the schema version this version of goose expects.
+func (m *Migrator) Latest() uint64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Migrations returns every migration built into goose.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Version returns the schema version of the database, which is zero if it
+// has never been migrated.
+func (m *Migrator) Version(ctx context.Context) (version uint64, dirty bool, err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	err = m.ensureVersionTable(ctx, conn)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return m.version(ctx, conn)
+}
+
+// Check returns an error if the database schema isn't one this version of
+// goose can use.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = m.migratable(version, dirty)
+	if err != nil {
+		return err
+	}
+
+	if version < m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, goose needs %d", ErrSchemaOutdated, version, m.Latest())
+	}
+
+	return nil
+}
+
+// migratable returns an error if goose shouldn't touch a database at
+// version.
+func (m *Migrator) migratable(version uint64, dirty bool) error {
+	if dirty {
+		return fmt.Errorf("%w at version %d, it has to be fixed by hand", ErrDirtySchema, version)
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, goose only knows up to %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies every migration the database hasn't had yet. It returns
+// ErrSchemaTooNew if the database was migrated by a newer goose.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		err = m.migratable(version, dirty)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				continue
+			}
+
+			err = m.apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the last steps migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		err = m.migratable(version, dirty)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > version {
+				continue
+			}
+
+			var previous uint64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			err = m.apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// locked runs fn with the migration lock held, so only one goose process
+// migrates at a time. SQLite already only allows one writer.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect == dialectPostgres {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID)
+		if err != nil {
+			return fmt.Errorf("take migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}
+
+	err = m.ensureVersionTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`)
+	return err
+}
+
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (version uint64, dirty bool, err error) {
+	err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// apply runs a migration and records the resulting version in the same
+// transaction, so a failed migration leaves the schema as it was.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration string, version uint64) error {
+	// SQLite can only change some columns by copying the table, and
+	// dropping the old copy would cascade to every row that refers to it.
+	// Foreign keys can't be switched off inside a transaction, so they're
+	// switched off around it and checked by hand before committing.
+	if m.dialect == dialectSQLite {
+		_, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		return err
+	}
+
+	if m.dialect == dialectSQLite {
+		err = checkForeignKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if any row refers to a row that doesn't exist.
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table string
+		var rowid sql.NullInt64
+		var parent string
+		var fkid int64
+		err := rows.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("row %d in %s refers to a missing row in %s", rowid.Int64, table, parent)
+	}
+
+	return rows.Err()
+}
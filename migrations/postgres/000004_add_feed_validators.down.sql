@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS feeds
+    DROP COLUMN IF EXISTS etag,
+    DROP COLUMN IF EXISTS last_modified;
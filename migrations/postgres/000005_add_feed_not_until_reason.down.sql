@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS feeds
+    DROP COLUMN IF EXISTS not_until_reason;
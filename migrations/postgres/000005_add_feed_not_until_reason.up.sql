@@ -0,0 +1,2 @@
+ALTER TABLE feeds
+    ADD COLUMN IF NOT EXISTS not_until_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE IF EXISTS feeds
+    DROP COLUMN IF EXISTS consecutive_failures,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS last_success_at,
+    DROP COLUMN IF EXISTS disabled;
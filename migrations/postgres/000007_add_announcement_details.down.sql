@@ -0,0 +1,7 @@
+ALTER TABLE IF EXISTS articles
+    DROP COLUMN IF EXISTS author,
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS image_url;
+
+ALTER TABLE IF EXISTS feeds
+    DROP COLUMN IF EXISTS title;
@@ -0,0 +1,7 @@
+ALTER TABLE feeds
+    ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS image_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS subscriptions
+    DROP COLUMN IF EXISTS template;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS filters;
+
+ALTER TABLE IF EXISTS articles
+    DROP COLUMN IF EXISTS categories;
@@ -0,0 +1,12 @@
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS categories TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS filters (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    field TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS subscriptions
+    DROP COLUMN IF EXISTS channel_type,
+    DROP COLUMN IF EXISTS crosspost;
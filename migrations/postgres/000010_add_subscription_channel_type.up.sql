@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS channel_type INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS crosspost BOOLEAN NOT NULL DEFAULT FALSE;
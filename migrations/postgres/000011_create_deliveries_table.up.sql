@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    article_id BIGINT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    not_before TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    discord_message_id TEXT NOT NULL DEFAULT '',
+    UNIQUE(subscription_id, article_id),
+    CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE,
+    CONSTRAINT fkey_article FOREIGN KEY(article_id) REFERENCES articles(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS deliveries_pending_idx ON deliveries (not_before) WHERE status = 'pending';
+
+-- Queue up everything that would have been announced before deliveries
+-- were tracked.
+INSERT INTO deliveries (subscription_id, article_id, not_before)
+SELECT subscriptions.id, articles.id, NOW()
+FROM subscriptions
+INNER JOIN articles ON subscriptions.feed_id=articles.feed_id
+WHERE articles.pub_date > subscriptions.last_pub_date
+ON CONFLICT (subscription_id, article_id) DO NOTHING;
@@ -0,0 +1,11 @@
+-- Collection names used to be unique only by convention. Keep the oldest
+-- subscription's name and tell the others apart by their ID.
+UPDATE subscriptions SET collection_name = collection_name || ' #' || id
+WHERE EXISTS (
+    SELECT 1 FROM subscriptions older
+    WHERE older.server_id = subscriptions.server_id
+    AND older.collection_name = subscriptions.collection_name
+    AND older.id < subscriptions.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_collection_name_idx ON subscriptions (server_id, collection_name);
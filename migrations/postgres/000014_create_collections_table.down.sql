@@ -0,0 +1,64 @@
+-- Every feed in a collection goes back to being a subscription of its
+-- own. Collection names have to be unique again, so all but the first
+-- feed get the subscription ID added to the name, and the filters stay
+-- with the first feed.
+ALTER TABLE filters ADD COLUMN subscription_id BIGINT;
+
+UPDATE filters SET subscription_id = (
+    SELECT MIN(subscriptions.id) FROM subscriptions WHERE subscriptions.collection_id = filters.collection_id
+);
+
+DELETE FROM filters WHERE subscription_id IS NULL;
+
+ALTER TABLE filters
+    ALTER COLUMN subscription_id SET NOT NULL,
+    ADD CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE,
+    DROP COLUMN collection_id;
+
+ALTER TABLE subscriptions
+    ADD COLUMN server_id TEXT,
+    ADD COLUMN channel_id TEXT,
+    ADD COLUMN collection_name TEXT,
+    ADD COLUMN template TEXT NOT NULL DEFAULT '',
+    ADD COLUMN channel_type INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN crosspost BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE subscriptions SET
+    server_id = collections.server_id,
+    channel_id = collections.channel_id,
+    collection_name = collections.name,
+    template = collections.template,
+    channel_type = collections.channel_type,
+    crosspost = collections.crosspost
+FROM collections
+WHERE collections.id = subscriptions.collection_id;
+
+UPDATE subscriptions SET collection_name = collection_name || ' #' || id
+WHERE EXISTS (
+    SELECT 1 FROM subscriptions older
+    WHERE older.collection_id = subscriptions.collection_id
+    AND older.id < subscriptions.id
+);
+
+-- goose doesn't let two collections announce a feed to the same channel,
+-- but the old table only has room for one such subscription should it
+-- have happened anyway.
+DELETE FROM subscriptions
+WHERE EXISTS (
+    SELECT 1 FROM subscriptions older
+    WHERE older.feed_id = subscriptions.feed_id
+    AND older.server_id = subscriptions.server_id
+    AND older.channel_id = subscriptions.channel_id
+    AND older.id < subscriptions.id
+);
+
+ALTER TABLE subscriptions
+    ALTER COLUMN server_id SET NOT NULL,
+    ALTER COLUMN channel_id SET NOT NULL,
+    ALTER COLUMN collection_name SET NOT NULL,
+    ADD UNIQUE(feed_id, server_id, channel_id),
+    DROP COLUMN collection_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_collection_name_idx ON subscriptions (server_id, collection_name);
+
+DROP TABLE IF EXISTS collections;
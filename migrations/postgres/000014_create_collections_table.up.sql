@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id BIGSERIAL PRIMARY KEY,
+    server_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    template TEXT NOT NULL DEFAULT '',
+    channel_type INTEGER NOT NULL DEFAULT 0,
+    crosspost BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(server_id, name)
+);
+
+-- Every existing subscription becomes a collection of one feed.
+INSERT INTO collections (server_id, name, channel_id, template, channel_type, crosspost)
+SELECT server_id, collection_name, channel_id, template, channel_type, crosspost
+FROM subscriptions
+ORDER BY id;
+
+ALTER TABLE subscriptions ADD COLUMN collection_id BIGINT;
+
+UPDATE subscriptions SET collection_id = collections.id
+FROM collections
+WHERE collections.server_id = subscriptions.server_id AND collections.name = subscriptions.collection_name;
+
+ALTER TABLE subscriptions
+    ALTER COLUMN collection_id SET NOT NULL,
+    ADD CONSTRAINT fkey_collection FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+    ADD UNIQUE(collection_id, feed_id),
+    DROP COLUMN server_id,
+    DROP COLUMN channel_id,
+    DROP COLUMN collection_name,
+    DROP COLUMN template,
+    DROP COLUMN channel_type,
+    DROP COLUMN crosspost;
+
+ALTER TABLE filters ADD COLUMN collection_id BIGINT;
+
+UPDATE filters SET collection_id = subscriptions.collection_id
+FROM subscriptions
+WHERE subscriptions.id = filters.subscription_id;
+
+ALTER TABLE filters
+    ALTER COLUMN collection_id SET NOT NULL,
+    ADD CONSTRAINT fkey_collection FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+    DROP COLUMN subscription_id;
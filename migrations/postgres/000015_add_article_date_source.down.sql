@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS articles
+    DROP COLUMN IF EXISTS date_source,
+    DROP COLUMN IF EXISTS first_seen;
@@ -0,0 +1,8 @@
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS date_source TEXT NOT NULL DEFAULT 'published',
+    ADD COLUMN IF NOT EXISTS first_seen TIMESTAMP WITH TIME ZONE;
+
+-- Articles used to be skipped unless they had a publication date.
+UPDATE articles SET first_seen = pub_date WHERE first_seen IS NULL;
+
+ALTER TABLE articles ALTER COLUMN first_seen SET NOT NULL;
@@ -0,0 +1,13 @@
+-- Links have to be unique again, so only the first article with each link
+-- is kept.
+DELETE FROM articles
+WHERE EXISTS (
+    SELECT 1 FROM articles older
+    WHERE older.link = articles.link
+    AND older.id < articles.id
+);
+
+ALTER TABLE articles
+    DROP CONSTRAINT IF EXISTS articles_feed_id_guid_key,
+    DROP COLUMN IF EXISTS guid,
+    ADD CONSTRAINT articles_link_key UNIQUE(link);
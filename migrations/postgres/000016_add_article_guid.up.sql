@@ -0,0 +1,11 @@
+-- Articles used to be identified by their link across every feed. The
+-- GUIDs of existing articles aren't known, so they're marked with their
+-- link and take on their real GUID the next time their feed is crawled.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS guid TEXT;
+
+UPDATE articles SET guid = 'legacy:' || link WHERE guid IS NULL;
+
+ALTER TABLE articles
+    ALTER COLUMN guid SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS articles_link_key,
+    ADD CONSTRAINT articles_feed_id_guid_key UNIQUE(feed_id, guid);
@@ -0,0 +1,7 @@
+ALTER TABLE IF EXISTS deliveries DROP COLUMN IF EXISTS is_update;
+
+ALTER TABLE IF EXISTS articles
+    DROP COLUMN IF EXISTS content_hash,
+    DROP COLUMN IF EXISTS updated_at;
+
+ALTER TABLE IF EXISTS collections DROP COLUMN IF EXISTS update_mode;
@@ -0,0 +1,9 @@
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS update_mode TEXT NOT NULL DEFAULT 'off';
+
+-- Articles stored before updates were tracked have no hash. They get one
+-- the next time their feed is crawled, without being announced again.
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS is_update BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+ALTER TABLE IF EXISTS articles
+    DROP COLUMN IF EXISTS media_url,
+    DROP COLUMN IF EXISTS media_type,
+    DROP COLUMN IF EXISTS episode,
+    DROP COLUMN IF EXISTS season,
+    DROP COLUMN IF EXISTS duration_secs;
@@ -0,0 +1,6 @@
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS media_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS media_type TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS episode TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS season TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS duration_secs INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS deliveries DROP COLUMN IF EXISTS discord_channel_id;
@@ -0,0 +1,9 @@
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS discord_channel_id TEXT NOT NULL DEFAULT '';
+
+-- Announcements made before their channel was recorded are taken to be
+-- where their collection announces now, or in the post they started in
+-- a forum channel.
+UPDATE deliveries SET discord_channel_id = CASE WHEN collections.channel_type = 15 THEN deliveries.discord_message_id ELSE collections.channel_id END
+FROM subscriptions
+INNER JOIN collections ON subscriptions.collection_id = collections.id
+WHERE deliveries.subscription_id = subscriptions.id AND deliveries.discord_message_id <> '';
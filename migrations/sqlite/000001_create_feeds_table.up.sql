@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS feeds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    link TEXT NOT NULL,
+    not_until TIMESTAMP NOT NULL,
+    UNIQUE(link)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS articles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    link TEXT NOT NULL,
+    pub_date TIMESTAMP NOT NULL,
+    UNIQUE(link),
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
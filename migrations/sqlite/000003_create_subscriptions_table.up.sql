@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    collection_name TEXT NOT NULL,
+    last_pub_date TIMESTAMP,
+    UNIQUE(feed_id, server_id, channel_id),
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE feeds DROP COLUMN etag;
+ALTER TABLE feeds DROP COLUMN last_modified;
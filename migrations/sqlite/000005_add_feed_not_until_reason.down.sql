@@ -0,0 +1 @@
+ALTER TABLE feeds DROP COLUMN not_until_reason;
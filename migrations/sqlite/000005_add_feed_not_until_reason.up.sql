@@ -0,0 +1 @@
+ALTER TABLE feeds ADD COLUMN not_until_reason TEXT NOT NULL DEFAULT '';
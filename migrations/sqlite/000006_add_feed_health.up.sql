@@ -0,0 +1,4 @@
+ALTER TABLE feeds ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE feeds ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE feeds ADD COLUMN last_success_at TIMESTAMP;
+ALTER TABLE feeds ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+ALTER TABLE articles DROP COLUMN author;
+ALTER TABLE articles DROP COLUMN description;
+ALTER TABLE articles DROP COLUMN image_url;
+
+ALTER TABLE feeds DROP COLUMN title;
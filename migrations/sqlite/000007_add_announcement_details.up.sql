@@ -0,0 +1,5 @@
+ALTER TABLE feeds ADD COLUMN title TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE articles ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN image_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN template TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS filters;
+
+ALTER TABLE articles DROP COLUMN categories;
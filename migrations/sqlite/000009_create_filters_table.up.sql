@@ -0,0 +1,12 @@
+-- Categories are stored in PostgreSQL's array syntax, e.g. {"a","b"}.
+ALTER TABLE articles ADD COLUMN categories TEXT NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS filters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE
+);
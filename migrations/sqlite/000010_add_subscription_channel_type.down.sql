@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN channel_type;
+ALTER TABLE subscriptions DROP COLUMN crosspost;
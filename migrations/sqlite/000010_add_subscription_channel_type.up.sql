@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN channel_type INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN crosspost BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    article_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    not_before TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    discord_message_id TEXT NOT NULL DEFAULT '',
+    UNIQUE(subscription_id, article_id),
+    CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE,
+    CONSTRAINT fkey_article FOREIGN KEY(article_id) REFERENCES articles(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS deliveries_pending_idx ON deliveries (not_before) WHERE status = 'pending';
@@ -0,0 +1,61 @@
+-- Every feed in a collection goes back to being a subscription of its
+-- own. Collection names have to be unique again, so all but the first
+-- feed get the subscription ID added to the name, and the filters stay
+-- with the first feed.
+CREATE TABLE subscriptions_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    collection_name TEXT NOT NULL,
+    last_pub_date TIMESTAMP,
+    template TEXT NOT NULL DEFAULT '',
+    channel_type INTEGER NOT NULL DEFAULT 0,
+    crosspost BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(feed_id, server_id, channel_id),
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
+
+INSERT OR IGNORE INTO subscriptions_old (id, feed_id, server_id, channel_id, collection_name, last_pub_date, template, channel_type, crosspost)
+SELECT
+    subscriptions.id,
+    subscriptions.feed_id,
+    collections.server_id,
+    collections.channel_id,
+    CASE
+        WHEN subscriptions.id = (SELECT MIN(first.id) FROM subscriptions first WHERE first.collection_id = subscriptions.collection_id) THEN collections.name
+        ELSE collections.name || ' #' || subscriptions.id
+    END,
+    subscriptions.last_pub_date,
+    collections.template,
+    collections.channel_type,
+    collections.crosspost
+FROM subscriptions
+INNER JOIN collections ON collections.id = subscriptions.collection_id
+ORDER BY subscriptions.id;
+
+CREATE TABLE filters_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    CONSTRAINT fkey_subscription FOREIGN KEY(subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE
+);
+
+INSERT INTO filters_old (id, subscription_id, field, mode, kind, pattern)
+SELECT filters.id, first.id, filters.field, filters.mode, filters.kind, filters.pattern
+FROM filters
+INNER JOIN subscriptions_old first ON first.id = (SELECT MIN(subscriptions.id) FROM subscriptions WHERE subscriptions.collection_id = filters.collection_id);
+
+DELETE FROM deliveries WHERE subscription_id NOT IN (SELECT id FROM subscriptions_old);
+
+DROP TABLE filters;
+DROP TABLE subscriptions;
+DROP TABLE collections;
+
+ALTER TABLE subscriptions_old RENAME TO subscriptions;
+ALTER TABLE filters_old RENAME TO filters;
+
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_collection_name_idx ON subscriptions (server_id, collection_name);
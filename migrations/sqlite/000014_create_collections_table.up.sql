@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    template TEXT NOT NULL DEFAULT '',
+    channel_type INTEGER NOT NULL DEFAULT 0,
+    crosspost BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(server_id, name)
+);
+
+-- Every existing subscription becomes a collection of one feed.
+INSERT INTO collections (server_id, name, channel_id, template, channel_type, crosspost)
+SELECT server_id, collection_name, channel_id, template, channel_type, crosspost
+FROM subscriptions
+ORDER BY id;
+
+-- SQLite can't drop columns that are part of a constraint, so the
+-- subscriptions and filters tables are rebuilt. Subscription IDs are kept
+-- so that deliveries still point at the right rows.
+CREATE TABLE subscriptions_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collection_id INTEGER NOT NULL,
+    feed_id INTEGER NOT NULL,
+    last_pub_date TIMESTAMP,
+    UNIQUE(collection_id, feed_id),
+    CONSTRAINT fkey_collection FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
+
+INSERT INTO subscriptions_new (id, collection_id, feed_id, last_pub_date)
+SELECT subscriptions.id, collections.id, subscriptions.feed_id, subscriptions.last_pub_date
+FROM subscriptions
+INNER JOIN collections ON collections.server_id = subscriptions.server_id AND collections.name = subscriptions.collection_name;
+
+DROP TABLE subscriptions;
+ALTER TABLE subscriptions_new RENAME TO subscriptions;
+
+CREATE TABLE filters_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collection_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    CONSTRAINT fkey_collection FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE CASCADE
+);
+
+INSERT INTO filters_new (id, collection_id, field, mode, kind, pattern)
+SELECT filters.id, subscriptions.collection_id, filters.field, filters.mode, filters.kind, filters.pattern
+FROM filters
+INNER JOIN subscriptions ON subscriptions.id = filters.subscription_id;
+
+DROP TABLE filters;
+ALTER TABLE filters_new RENAME TO filters;
@@ -0,0 +1,2 @@
+ALTER TABLE articles DROP COLUMN date_source;
+ALTER TABLE articles DROP COLUMN first_seen;
@@ -0,0 +1,5 @@
+ALTER TABLE articles ADD COLUMN date_source TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE articles ADD COLUMN first_seen TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+-- Articles used to be skipped unless they had a publication date.
+UPDATE articles SET first_seen = pub_date;
@@ -0,0 +1,35 @@
+-- Links have to be unique again, so only the first article with each link
+-- is kept.
+DELETE FROM deliveries
+WHERE article_id IN (
+    SELECT id FROM articles
+    WHERE EXISTS (
+        SELECT 1 FROM articles older
+        WHERE older.link = articles.link
+        AND older.id < articles.id
+    )
+);
+
+CREATE TABLE articles_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    link TEXT NOT NULL,
+    pub_date TIMESTAMP NOT NULL,
+    author TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    categories TEXT NOT NULL DEFAULT '{}',
+    date_source TEXT NOT NULL DEFAULT 'published',
+    first_seen TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00',
+    UNIQUE(link),
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
+
+INSERT OR IGNORE INTO articles_old (id, feed_id, title, link, pub_date, author, description, image_url, categories, date_source, first_seen)
+SELECT id, feed_id, title, link, pub_date, author, description, image_url, categories, date_source, first_seen
+FROM articles
+ORDER BY id;
+
+DROP TABLE articles;
+ALTER TABLE articles_old RENAME TO articles;
@@ -0,0 +1,29 @@
+-- Articles used to be identified by their link across every feed. The
+-- GUIDs of existing articles aren't known, so they're marked with their
+-- link and take on their real GUID the next time their feed is crawled.
+--
+-- SQLite can't drop a UNIQUE constraint, so the table is rebuilt. Article
+-- IDs are kept so that deliveries still point at the right rows.
+CREATE TABLE articles_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    guid TEXT NOT NULL,
+    title TEXT NOT NULL,
+    link TEXT NOT NULL,
+    pub_date TIMESTAMP NOT NULL,
+    author TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    categories TEXT NOT NULL DEFAULT '{}',
+    date_source TEXT NOT NULL DEFAULT 'published',
+    first_seen TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00',
+    UNIQUE(feed_id, guid),
+    CONSTRAINT fkey_feed FOREIGN KEY(feed_id) REFERENCES feeds(id)
+);
+
+INSERT INTO articles_new (id, feed_id, guid, title, link, pub_date, author, description, image_url, categories, date_source, first_seen)
+SELECT id, feed_id, 'legacy:' || link, title, link, pub_date, author, description, image_url, categories, date_source, first_seen
+FROM articles;
+
+DROP TABLE articles;
+ALTER TABLE articles_new RENAME TO articles;
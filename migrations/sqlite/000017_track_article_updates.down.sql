@@ -0,0 +1,6 @@
+ALTER TABLE deliveries DROP COLUMN is_update;
+
+ALTER TABLE articles DROP COLUMN content_hash;
+ALTER TABLE articles DROP COLUMN updated_at;
+
+ALTER TABLE collections DROP COLUMN update_mode;
@@ -0,0 +1,8 @@
+ALTER TABLE collections ADD COLUMN update_mode TEXT NOT NULL DEFAULT 'off';
+
+-- Articles stored before updates were tracked have no hash. They get one
+-- the next time their feed is crawled, without being announced again.
+ALTER TABLE articles ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN updated_at TIMESTAMP;
+
+ALTER TABLE deliveries ADD COLUMN is_update BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+ALTER TABLE articles DROP COLUMN media_url;
+ALTER TABLE articles DROP COLUMN media_type;
+ALTER TABLE articles DROP COLUMN episode;
+ALTER TABLE articles DROP COLUMN season;
+ALTER TABLE articles DROP COLUMN duration_secs;
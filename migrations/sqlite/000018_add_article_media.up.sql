@@ -0,0 +1,5 @@
+ALTER TABLE articles ADD COLUMN media_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN media_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN episode TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN season TEXT NOT NULL DEFAULT '';
+ALTER TABLE articles ADD COLUMN duration_secs INTEGER NOT NULL DEFAULT 0;
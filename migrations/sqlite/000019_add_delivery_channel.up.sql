@@ -0,0 +1,12 @@
+ALTER TABLE deliveries ADD COLUMN discord_channel_id TEXT NOT NULL DEFAULT '';
+
+-- Announcements made before their channel was recorded are taken to be
+-- where their collection announces now, or in the post they started in
+-- a forum channel.
+UPDATE deliveries SET discord_channel_id = (
+    SELECT CASE WHEN collections.channel_type = 15 THEN deliveries.discord_message_id ELSE collections.channel_id END
+    FROM subscriptions
+    INNER JOIN collections ON subscriptions.collection_id = collections.id
+    WHERE subscriptions.id = deliveries.subscription_id
+)
+WHERE discord_message_id <> '';
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+// Schema versions that the migration tests go back to. Migrations added
+// later don't change them.
+const (
+	versionBeforeUniqueCollectionNames = 12
+	versionBeforeCollections           = 13
+	versionBeforeArticleGUIDs          = 15
+	versionBeforeDeliveryChannels      = 18
+)
+
+// stepsDownTo is how many migrations m has to undo to get from the latest
+// schema back to version.
+func stepsDownTo(m *Migrator, version uint64) int {
+	return int(m.Latest() - version)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/000002_add_things.up.sql":      {Data: []byte("up 2")},
+		"m/000002_add_things.down.sql":    {Data: []byte("down 2")},
+		"m/000001_create_stuff.up.sql":    {Data: []byte("up 1")},
+		"m/000001_create_stuff.down.sql":  {Data: []byte("down 1")},
+		"m/README.md":                     {Data: []byte("not a migration")},
+		"broken/000001_only_up.up.sql":    {Data: []byte("up 1")},
+		"unversioned/create_stuff.up.sql": {Data: []byte("up")},
+	}
+
+	migrations, err := loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v", err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "create_stuff", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "add_things", Up: "up 2", Down: "down 2"},
+	}
+	if len(migrations) != len(want) || migrations[0] != want[0] || migrations[1] != want[1] {
+		t.Fatalf("want migrations [%+v], got [%+v]", want, migrations)
+	}
+
+	_, err = loadMigrations(fsys, "broken")
+	if err == nil {
+		t.Fatalf("want an error for a migration without a down")
+	}
+
+	_, err = loadMigrations(fsys, "unversioned")
+	if err == nil {
+		t.Fatalf("want an error for a migration without a version")
+	}
+}
+
+func TestEmbeddedMigrationsMatch(t *testing.T) {
+	postgres, err := loadMigrations(migrationFiles, "migrations/postgres")
+	if err != nil {
+		t.Fatalf("load postgres migrations: %v", err)
+	}
+
+	sqlite, err := loadMigrations(migrationFiles, "migrations/sqlite")
+	if err != nil {
+		t.Fatalf("load sqlite migrations: %v", err)
+	}
+
+	// Both backends have to agree on what each schema version means.
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("want the same number of migrations, got %d for postgres and %d for sqlite", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("want matching migrations, got %d_%s for postgres and %d_%s for sqlite",
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+
+	migrator, err := NewMigrator(db, dialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	err = migrator.Check(ctx)
+	if !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("want err=%v, got err=%v for a new database", ErrSchemaOutdated, err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up", err)
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil || version != migrator.Latest() || dirty {
+		t.Fatalf("want version=%d dirty=false err=<nil>, got version=%d dirty=%v err=%v", migrator.Latest(), version, dirty, err)
+	}
+
+	err = migrator.Check(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v after migrating up", err)
+	}
+
+	// Migrating up again has nothing to do.
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up twice", err)
+	}
+
+	// Go back to before collections.
+	err = migrator.Down(ctx, stepsDownTo(migrator, versionBeforeCollections))
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating down", err)
+	}
+
+	migrations := migrator.Migrations()
+	want := uint64(versionBeforeCollections)
+
+	version, _, err = migrator.Version(ctx)
+	if err != nil || version != want {
+		t.Fatalf("want version=%d, got version=%d err=%v", want, version, err)
+	}
+
+	_, err = db.Exec(`SELECT * FROM collections`)
+	if err == nil {
+		t.Fatalf("want collections table to be gone after migrating down")
+	}
+
+	err = migrator.Down(ctx, len(migrations))
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating all the way down", err)
+	}
+
+	version, _, err = migrator.Version(ctx)
+	if err != nil || version != 0 {
+		t.Fatalf("want version=0, got version=%d err=%v", version, err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating back up", err)
+	}
+
+	// A newer goose migrated the database past what this one knows.
+	_, err = db.Exec(`UPDATE schema_migrations SET version = $1`, migrator.Latest()+1)
+	if err != nil {
+		t.Fatalf("bump schema version: %v", err)
+	}
+
+	err = migrator.Check(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("want err=%v, got err=%v", ErrSchemaTooNew, err)
+	}
+
+	err = migrator.Up(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("want err=%v, got err=%v when migrating up", ErrSchemaTooNew, err)
+	}
+
+	_, err = db.Exec(`UPDATE schema_migrations SET version = $1, dirty = $2`, migrator.Latest(), true)
+	if err != nil {
+		t.Fatalf("mark schema dirty: %v", err)
+	}
+
+	err = migrator.Check(ctx)
+	if !errors.Is(err, ErrDirtySchema) {
+		t.Fatalf("want err=%v, got err=%v", ErrDirtySchema, err)
+	}
+}
+
+func TestMigrationRenamesDuplicateCollections(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+
+	migrator, err := NewMigrator(db, dialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// Go back to before collection names had to be unique.
+	err = migrator.Down(ctx, stepsDownTo(migrator, versionBeforeUniqueCollectionNames))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	stmts := []string{
+		`INSERT INTO feeds (id, link, not_until) VALUES (1, 'http://example.com/rss', '2000-01-01 00:00:00')`,
+		`INSERT INTO subscriptions (id, feed_id, server_id, channel_id, collection_name, last_pub_date) VALUES (1, 1, 'server1', 'channel1', 'honk', '2000-01-01 00:00:00')`,
+		`INSERT INTO subscriptions (id, feed_id, server_id, channel_id, collection_name, last_pub_date) VALUES (2, 1, 'server1', 'channel2', 'honk', '2000-01-01 00:00:00')`,
+		`INSERT INTO subscriptions (id, feed_id, server_id, channel_id, collection_name, last_pub_date) VALUES (3, 1, 'server2', 'channel3', 'honk', '2000-01-01 00:00:00')`,
+	}
+	for _, stmt := range stmts {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up with duplicate collection names", err)
+	}
+
+	want := map[int64]string{1: "honk", 2: "honk #2", 3: "honk"}
+	for id, name := range want {
+		var got string
+		err = db.QueryRow(`SELECT collections.name FROM subscriptions INNER JOIN collections ON collections.id = subscriptions.collection_id WHERE subscriptions.id = $1`, id).Scan(&got)
+		if err != nil {
+			t.Fatalf("get subscription %d: %v", id, err)
+		}
+		if got != name {
+			t.Fatalf("want subscription %d named %q, got %q", id, name, got)
+		}
+	}
+}
+
+func TestMigrationGroupsSubscriptionsIntoCollections(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+
+	migrator, err := NewMigrator(db, dialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// Go back to when every subscription was its own collection.
+	err = migrator.Down(ctx, stepsDownTo(migrator, versionBeforeCollections))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	stmts := []string{
+		`INSERT INTO feeds (id, link, not_until) VALUES (1, 'http://example.com/rss', '2000-01-01 00:00:00')`,
+		`INSERT INTO articles (id, feed_id, title, link, pub_date) VALUES (1, 1, 'honk', 'http://example.com/honk', '2000-01-01 00:00:00')`,
+		`INSERT INTO subscriptions (id, feed_id, server_id, channel_id, collection_name, last_pub_date, template) VALUES (7, 1, 'server1', 'channel1', 'honk', '2000-01-01 00:00:00', '{{.Title}}')`,
+		`INSERT INTO filters (id, subscription_id, field, mode, kind, pattern) VALUES (1, 7, 'title', 'include', 'keyword', 'honk')`,
+		`INSERT INTO deliveries (id, subscription_id, article_id, not_before) VALUES (1, 7, 1, '2000-01-01 00:00:00')`,
+	}
+	for _, stmt := range stmts {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up", err)
+	}
+
+	collection, err := (&Collections{db: db}).GetByName("server1", "honk")
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when getting the collection", err)
+	}
+
+	if collection.ChannelID != "channel1" || collection.Template != "{{.Title}}" {
+		t.Fatalf("want the collection to keep the subscription's channel and template, got [%+v]", *collection)
+	}
+
+	subs, err := (&Subscriptions{db: db}).ListByCollection(collection.ID)
+	if err != nil || len(subs) != 1 || subs[0].ID != 7 {
+		t.Fatalf("want subscription 7 in the collection, got %+v err=%v", subs, err)
+	}
+
+	filters, err := (&Filters{db: db}).ListByCollection(collection.ID)
+	if err != nil || len(filters) != 1 {
+		t.Fatalf("want the filter to move to the collection, got %+v err=%v", filters, err)
+	}
+
+	var deliveries int
+	err = db.QueryRow(`SELECT COUNT(*) FROM deliveries WHERE subscription_id = 7`).Scan(&deliveries)
+	if err != nil || deliveries != 1 {
+		t.Fatalf("want the pending delivery to survive, got %d err=%v", deliveries, err)
+	}
+
+	// And back again.
+	err = migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating down", err)
+	}
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM deliveries WHERE subscription_id = 7`).Scan(&deliveries)
+	if err != nil || deliveries != 1 {
+		t.Fatalf("want the pending delivery to survive migrating down, got %d err=%v", deliveries, err)
+	}
+}
+
+func TestMigrationKeepsArticlesByGUID(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+
+	migrator, err := NewMigrator(db, dialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// Go back to when articles were identified by their link.
+	err = migrator.Down(ctx, stepsDownTo(migrator, versionBeforeArticleGUIDs))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	stmts := []string{
+		`INSERT INTO feeds (id, link, not_until) VALUES (1, 'http://example.com/rss', '2000-01-01 00:00:00')`,
+		`INSERT INTO articles (id, feed_id, title, link, pub_date) VALUES (1, 1, 'honk', 'http://example.com/honk', '2000-01-01 00:00:00')`,
+	}
+	for _, stmt := range stmts {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up", err)
+	}
+
+	articles := &Articles{db: db}
+
+	// The article is seen again with its GUID, which it takes on rather
+	// than being added again.
+	_, err = articles.Create(Article{FeedID: 1, GUID: "honk-1", Title: "honk", Link: "http://example.com/honk"})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("want err=%v, got err=%v for an article from before the migration", ErrAlreadyExists, err)
+	}
+
+	var guid string
+	err = db.QueryRow(`SELECT guid FROM articles WHERE id = 1`).Scan(&guid)
+	if err != nil || guid != "honk-1" {
+		t.Fatalf("want guid=%q, got guid=%q err=%v", "honk-1", guid, err)
+	}
+
+	_, err = articles.Create(Article{FeedID: 1, GUID: "honk-1", Title: "honk", Link: "http://example.com/honk"})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("want err=%v, got err=%v when seeing the article a third time", ErrAlreadyExists, err)
+	}
+
+	// Another item may link to the same page once GUIDs are known.
+	_, err = articles.Create(Article{FeedID: 1, GUID: "honk-2", Title: "honk again", Link: "http://example.com/honk"})
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v for a new item linking to the same page", err)
+	}
+}
+
+func TestMigrationRecordsDeliveryChannels(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+
+	migrator, err := NewMigrator(db, dialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// Go back to when deliveries only kept the announcement's message ID.
+	err = migrator.Down(ctx, stepsDownTo(migrator, versionBeforeDeliveryChannels))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	stmts := []string{
+		`INSERT INTO feeds (id, link, not_until) VALUES (1, 'http://example.com/rss', '2000-01-01 00:00:00')`,
+		`INSERT INTO collections (id, server_id, name, channel_id, channel_type) VALUES (1, 'server1', 'text', 'channel1', 0), (2, 'server1', 'forum', 'channel2', 15)`,
+		`INSERT INTO subscriptions (id, collection_id, feed_id) VALUES (1, 1, 1), (2, 2, 1)`,
+		`INSERT INTO articles (id, feed_id, guid, title, link, pub_date) VALUES (1, 1, 'honk-1', 'honk', 'http://example.com/honk', '2000-01-01 00:00:00')`,
+		`INSERT INTO deliveries (id, subscription_id, article_id, status, not_before, discord_message_id) VALUES
+			(1, 1, 1, 'sent', '2000-01-01 00:00:00', 'message1'),
+			(2, 2, 1, 'sent', '2000-01-01 00:00:00', 'post2')`,
+	}
+	for _, stmt := range stmts {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v when migrating up", err)
+	}
+
+	// Announcements are taken to be in their collection's channel, or in
+	// the post they started in a forum channel.
+	want := map[int64]string{1: "channel1", 2: "post2"}
+	for id, channelID := range want {
+		var got string
+		err = db.QueryRow(`SELECT discord_channel_id FROM deliveries WHERE id = $1`, id).Scan(&got)
+		if err != nil || got != channelID {
+			t.Fatalf("want delivery %d in channel %q, got %q err=%v", id, channelID, got, err)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// opmlSizeMax is the largest OPML attachment /import reads.
+	opmlSizeMax = 1 << 20
+
+	// opmlFeedsMax is the most feeds one /import subscribes to.
+	opmlFeedsMax = 100
+
+	// opmlImportWorkers is how many collections an import fills at once.
+	opmlImportWorkers = 4
+
+	// opmlImportTimeout is how long an import keeps starting on new
+	// feeds. It leaves time to send the results before Discord stops
+	// accepting them 15 minutes after /import.
+	opmlImportTimeout = 10 * time.Minute
+
+	// collectionNameMax keeps imported collection names short enough to
+	// be offered as autocomplete choices.
+	collectionNameMax = 100
+)
+
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title string `xml:"title"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+func (o *opmlOutline) name() string {
+	if o.Text != "" {
+		return o.Text
+	}
+	return o.Title
+}
+
+// opmlFeed is a feed found in an OPML file along with the collection it
+// belongs to.
+type opmlFeed struct {
+	Collection string
+	Link       string
+}
+
+// parseOPML lists the feeds in an OPML file. Feeds in a folder go into a
+// collection named after the innermost folder, and feeds outside of any
+// folder get a collection of their own named after the feed.
+func parseOPML(r io.Reader) ([]opmlFeed, error) {
+	var doc opml
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotOPML, err)
+	}
+
+	var feeds []opmlFeed
+
+	var walk func(outlines []opmlOutline, collection string)
+	walk = func(outlines []opmlOutline, collection string) {
+		for _, o := range outlines {
+			name := strings.TrimSpace(o.name())
+
+			if o.XMLURL != "" {
+				c := collection
+				if c == "" {
+					c = name
+				}
+				if c == "" {
+					if link, err := url.Parse(o.XMLURL); err == nil {
+						c = link.Hostname()
+					}
+				}
+				if c == "" {
+					c = o.XMLURL
+				}
+				feeds = append(feeds, opmlFeed{Collection: truncate(c, collectionNameMax), Link: o.XMLURL})
+			}
+
+			folder := collection
+			if name != "" {
+				folder = name
+			}
+			walk(o.Outlines, folder)
+		}
+	}
+	walk(doc.Body.Outlines, "")
+
+	return feeds, nil
+}
+
+type importResult struct {
+	Feed opmlFeed
+	Err  error
+}
+
+// importFeeds subscribes to the feeds, announcing every collection it
+// creates to the given channel. A feed that fails doesn't stop the rest
+// from being imported. Feeds past the first opmlFeedsMax are skipped with
+// ErrImportLimit, and feeds that weren't started on by deadline fail with
+// ErrImportTimeout.
+//
+// Several collections are filled at once, but the feeds in each one are
+// added one after another so that only the first of them creates it.
+func (b *Bot) importFeeds(serverID string, channel *discordgo.Channel, feeds []opmlFeed, deadline time.Time) []importResult {
+	results := make([]importResult, len(feeds))
+
+	var groups [][]int
+	group := make(map[string]int)
+	for n, f := range feeds {
+		results[n].Feed = f
+
+		if n >= opmlFeedsMax {
+			results[n].Err = ErrImportLimit
+			continue
+		}
+
+		g, ok := group[f.Collection]
+		if !ok {
+			g = len(groups)
+			group[f.Collection] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], n)
+	}
+
+	work := make(chan []int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < opmlImportWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for feedIndexes := range work {
+				for _, n := range feedIndexes {
+					if time.Now().After(deadline) {
+						results[n].Err = ErrImportTimeout
+						continue
+					}
+					results[n].Err = b.importFeed(serverID, channel, feeds[n])
+				}
+			}
+		}()
+	}
+
+	for _, feedIndexes := range groups {
+		work <- feedIndexes
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+// importFeed subscribes to a feed from an OPML file. The file lists feeds
+// rather than web pages, so there's no looking for feeds behind the link.
+func (b *Bot) importFeed(serverID string, channel *discordgo.Channel, f opmlFeed) error {
+	link, err := url.Parse(f.Link)
+	if err != nil || !strings.Contains(link.Scheme, "http") {
+		return ErrInvalidURL
+	}
+
+	return b.subscribeFeed(link, Collection{
+		ServerID:    serverID,
+		Name:        f.Collection,
+		ChannelID:   channel.ID,
+		ChannelType: channel.Type,
+	}, false)
+}
+
+// importFailure explains briefly why a feed couldn't be imported.
+func importFailure(err error) string {
+	var httpErr *ErrHTTP
+
+	switch {
+	case errors.Is(err, ErrImportLimit):
+		return "skipped"
+	case errors.Is(err, ErrImportTimeout):
+		return "not reached in time"
+	case errors.Is(err, ErrInvalidURL):
+		return "not a valid URL"
+	case errors.Is(err, ErrCollectionNameTaken):
+		return "the collection announces to another channel"
+	case errors.Is(err, ErrAlreadyExists):
+		return "the channel already gets its items through another collection"
+	case errors.Is(err, ErrNotRSSFeed):
+		return "not an RSS feed"
+	case errors.Is(err, ErrFeedTooLarge):
+		return "the feed is too big"
+	case errors.As(err, &httpErr):
+		return fmt.Sprintf("the website answered with HTTP %d", httpErr.StatusCode)
+	default:
+		return "couldn't fetch the feed"
+	}
+}
+
+// renderImport summarizes an import, leaving out lines that don't fit in
+// a message.
+func renderImport(results []importResult) string {
+	var failed, skipped, unreached int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+		if errors.Is(r.Err, ErrImportLimit) {
+			skipped++
+		}
+		if errors.Is(r.Err, ErrImportTimeout) {
+			unreached++
+		}
+	}
+
+	var sb strings.Builder
+	switch {
+	case skipped > 0:
+		fmt.Fprintf(&sb, "🪿 overwhelmed honk. I only import %d feeds at a time, so I skipped the last %d. I imported %d of the first %d:",
+			opmlFeedsMax, skipped, len(results)-failed, len(results)-skipped)
+	case unreached > 0:
+		fmt.Fprintf(&sb, "🪿 winded honk. I ran out of time before getting to %d feeds, so import the file again for those. I imported %d of %d:",
+			unreached, len(results)-failed, len(results))
+	case failed == 0:
+		fmt.Fprintf(&sb, "🪿 Affirmative HONK! I imported all %d feeds:", len(results))
+	default:
+		fmt.Fprintf(&sb, "🪿 Mixed honk. I imported %d of %d feeds:", len(results)-failed, len(results))
+	}
+
+	for n, r := range results {
+		var line string
+		if r.Err == nil {
+			line = fmt.Sprintf("\n✅ <%s> in %q", r.Feed.Link, r.Feed.Collection)
+		} else {
+			line = fmt.Sprintf("\n❌ <%s>: %s", r.Feed.Link, importFailure(r.Err))
+		}
+
+		more := fmt.Sprintf("\n…and %d more.", len(results)-n)
+		if sb.Len()+len(line)+len(more) > messageContentLimit {
+			sb.WriteString(more)
+			break
+		}
+		sb.WriteString(line)
+	}
+
+	return sb.String()
+}
+
+// exportOPML writes every collection in the server as an OPML folder of
+// its feeds, which /import turns back into the same collections.
+func (b *Bot) exportOPML(serverID string) ([]byte, error) {
+	collections, err := b.collections.ListByServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := opml{
+		Version: "2.0",
+		Head:    opmlHead{Title: "goose subscriptions"},
+	}
+
+	for _, c := range collections {
+		subs, err := b.subscriptions.ListByCollection(c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list subscriptions of collection %d: %w", c.ID, err)
+		}
+
+		folder := opmlOutline{Text: c.Name, Title: c.Name}
+		for _, sub := range subs {
+			feed, err := b.feeds.Get(sub.FeedID)
+			if err != nil {
+				return nil, fmt.Errorf("get feed %d: %w", sub.FeedID, err)
+			}
+
+			text := feed.Title
+			if text == "" {
+				text = feed.Link
+			}
+			folder.Outlines = append(folder.Outlines, opmlOutline{Text: text, Type: "rss", XMLURL: feed.Link})
+		}
+
+		doc.Body.Outlines = append(doc.Body.Outlines, folder)
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
+
+func (b *Bot) Import(s *discordgo.Session, i *discordgo.Interaction) {
+	data := i.ApplicationCommandData()
+	opts := optionsToMap(data.Options)
+	channel := resolvedChannel(s, data, opts[optionChannel])
+
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+		slog.String("announce_channel_id", channel.ID),
+	)
+
+	respond := func(msg string) {
+		if err := b.respondToInteraction(s, i, msg); err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+			return
+		}
+	}
+
+	var attachment *discordgo.MessageAttachment
+	if opt, ok := opts[optionFile]; ok && data.Resolved != nil {
+		if id, ok := opt.Value.(string); ok {
+			attachment = data.Resolved.Attachments[id]
+		}
+	}
+	if attachment == nil {
+		respond(`🪿 cOnFuSeD hOnK! Where's the OPML file?`)
+		return
+	}
+
+	if attachment.Size > opmlSizeMax {
+		respond(`🪿 overwhelmed honk. That file is too big for me.`)
+		return
+	}
+
+	// Fetching every feed can take longer than Discord waits for a
+	// response, so the results are sent once the import is done.
+	err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("defer response")
+		return
+	}
+
+	edit := func(msg string) {
+		_, err := s.InteractionResponseEdit(i, &discordgo.WebhookEdit{Content: &msg})
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("edit response")
+			return
+		}
+	}
+
+	feeds, err := b.fetchOPML(attachment.URL)
+	switch {
+	case errors.Is(err, ErrNotOPML):
+		edit(`🪿 cOnFuSeD hOnK! That doesn't look like an OPML file.`)
+		return
+	case err != nil:
+		logger.With(slog.Any("err", err)).Error("fetch OPML")
+		edit(`🪿 ashamed honk. I couldn't download that file.`)
+		return
+	case len(feeds) == 0:
+		edit(`🪿 lonely honk. There aren't any feeds in that file.`)
+		return
+	}
+
+	results := b.importFeeds(i.GuildID, channel, feeds, time.Now().Add(opmlImportTimeout))
+	for _, r := range results {
+		if r.Err != nil {
+			logger.With(slog.String("feed", r.Feed.Link), slog.Any("err", r.Err)).Warn("import feed")
+		}
+	}
+
+	edit(renderImport(results))
+}
+
+func (b *Bot) fetchOPML(link string) ([]opmlFeed, error) {
+	rsp, err := b.httpClient.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, &ErrHTTP{StatusCode: rsp.StatusCode}
+	}
+
+	return parseOPML(io.LimitReader(rsp.Body, opmlSizeMax))
+}
+
+func (b *Bot) Export(s *discordgo.Session, i *discordgo.Interaction) {
+	logger := slog.With(
+		slog.String("interaction_id", i.ID),
+		slog.String("guild_id", i.GuildID),
+		slog.String("channel_id", i.ChannelID),
+	)
+
+	names, err := b.collections.Names(i.GuildID)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("list collections")
+		b.respondInternalError(s, i)
+		return
+	}
+
+	if len(names) == 0 {
+		err := b.respondToInteraction(s, i, "🪿 lonely honk. This server isn't subscribed to any feeds yet.")
+		if err != nil {
+			logger.With(slog.Any("err", err)).Error("respond to interaction")
+		}
+		return
+	}
+
+	out, err := b.exportOPML(i.GuildID)
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("export OPML")
+		b.respondInternalError(s, i)
+		return
+	}
+
+	err = s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("🪿 Affirmative HONK! Here are the %d collections in this server.", len(names)),
+			Files: []*discordgo.File{{
+				Name:        "goose.opml",
+				ContentType: "text/x-opml",
+				Reader:      bytes.NewReader(out),
+			}},
+		},
+	})
+	if err != nil {
+		logger.With(slog.Any("err", err)).Error("respond to interaction")
+		return
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseOPML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>My feeds</title></head>
+  <body>
+    <outline text="Honk Times" type="rss" xmlUrl="http://example.com/honk.xml"/>
+    <outline title="Untitled" type="rss" xmlUrl="http://example.com/untitled.xml"/>
+    <outline type="rss" xmlUrl="http://nameless.example.com/rss"/>
+    <outline text="Birds">
+      <outline text="Geese" type="rss" xmlUrl="http://example.com/geese.xml"/>
+      <outline text="Ducks">
+        <outline text="Mallards" type="rss" xmlUrl="http://example.com/mallards.xml"/>
+      </outline>
+    </outline>
+  </body>
+</opml>`
+
+	feeds, err := parseOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("want err=<nil>, got err=%v", err)
+	}
+
+	want := []opmlFeed{
+		{Collection: "Honk Times", Link: "http://example.com/honk.xml"},
+		{Collection: "Untitled", Link: "http://example.com/untitled.xml"},
+		{Collection: "nameless.example.com", Link: "http://nameless.example.com/rss"},
+		{Collection: "Birds", Link: "http://example.com/geese.xml"},
+		{Collection: "Ducks", Link: "http://example.com/mallards.xml"},
+	}
+	if len(feeds) != len(want) {
+		t.Fatalf("want feeds %+v, got %+v", want, feeds)
+	}
+	for n := range want {
+		if feeds[n] != want[n] {
+			t.Fatalf("want feeds %+v, got %+v", want, feeds)
+		}
+	}
+
+	_, err = parseOPML(strings.NewReader(`<rss version="2.0"><channel></channel></rss>`))
+	if !errors.Is(err, ErrNotOPML) {
+		t.Fatalf("want err=%v, got err=%v for an RSS feed", ErrNotOPML, err)
+	}
+}
+
+func TestBotImportExport(t *testing.T) {
+	stores := NewMemoryStores()
+
+	feedServer := &testFeedServer{}
+	feedServer.add("Honk", time.Now().Add(-time.Hour))
+
+	mux := http.NewServeMux()
+	mux.Handle("/feed", feedServer)
+	mux.Handle("/other", feedServer)
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="alternate" type="application/rss+xml" href="/feed"></head></html>`)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	b := newTestBot(stores, srv.Client())
+
+	link, err := url.Parse(srv.URL + "/feed")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	// A collection that already announces somewhere else.
+	err = b.subscribe(link, Collection{ServerID: "server1", Name: "taken", ChannelID: "channel2"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	channel := &discordgo.Channel{ID: "channel1", Type: discordgo.ChannelTypeGuildText}
+	results := b.importFeeds("server1", channel, []opmlFeed{
+		{Collection: "honk", Link: srv.URL + "/feed"},
+		{Collection: "honk", Link: srv.URL + "/other"},
+		{Collection: "honk", Link: srv.URL + "/missing"},
+		{Collection: "taken", Link: srv.URL + "/other"},
+		{Collection: "honk", Link: "ftp://example.com/feed"},
+		{Collection: "page", Link: srv.URL + "/page"},
+	}, time.Now().Add(time.Minute))
+
+	var httpErr *ErrHTTP
+	switch {
+	case results[0].Err != nil, results[1].Err != nil:
+		t.Fatalf("want the first two feeds imported, got %+v", results)
+	case !errors.As(results[2].Err, &httpErr) || httpErr.StatusCode != http.StatusNotFound:
+		t.Fatalf("want a 404 for the missing feed, got err=%v", results[2].Err)
+	case !errors.Is(results[3].Err, ErrCollectionNameTaken):
+		t.Fatalf("want err=%v, got err=%v", ErrCollectionNameTaken, results[3].Err)
+	case !errors.Is(results[4].Err, ErrInvalidURL):
+		t.Fatalf("want err=%v, got err=%v", ErrInvalidURL, results[4].Err)
+	case !errors.Is(results[5].Err, ErrNotRSSFeed):
+		// OPML files list feeds, so web pages aren't searched for one.
+		t.Fatalf("want err=%v, got err=%v for a web page", ErrNotRSSFeed, results[5].Err)
+	}
+
+	summary := renderImport(results)
+	if !strings.HasPrefix(summary, "🪿 Mixed honk. I imported 2 of 6 feeds:") {
+		t.Fatalf("want a summary of the import, got %q", summary)
+	}
+
+	out, err := b.exportOPML("server1")
+	if err != nil {
+		t.Fatalf("exportOPML: %v", err)
+	}
+
+	feeds, err := parseOPML(strings.NewReader(string(out)))
+	if err != nil {
+		t.Fatalf("want the export to parse, got err=%v", err)
+	}
+
+	want := []opmlFeed{
+		{Collection: "honk", Link: srv.URL + "/feed"},
+		{Collection: "honk", Link: srv.URL + "/other"},
+		{Collection: "taken", Link: srv.URL + "/feed"},
+	}
+	if len(feeds) != len(want) {
+		t.Fatalf("want exported feeds %+v, got %+v", want, feeds)
+	}
+	for n := range want {
+		if feeds[n] != want[n] {
+			t.Fatalf("want exported feeds %+v, got %+v", want, feeds)
+		}
+	}
+}
+
+func TestBotImportLimit(t *testing.T) {
+	b := newTestBot(NewMemoryStores(), http.DefaultClient)
+
+	// The links aren't fetched, so only the limit decides which feeds
+	// are tried.
+	var feeds []opmlFeed
+	for n := 0; n < opmlFeedsMax+5; n++ {
+		feeds = append(feeds, opmlFeed{Collection: "honk", Link: "ftp://example.com/feed"})
+	}
+
+	channel := &discordgo.Channel{ID: "channel1", Type: discordgo.ChannelTypeGuildText}
+	results := b.importFeeds("server1", channel, feeds, time.Now().Add(time.Minute))
+
+	if len(results) != len(feeds) {
+		t.Fatalf("want a result for each of the %d feeds, got %d", len(feeds), len(results))
+	}
+	for n, r := range results {
+		want := ErrInvalidURL
+		if n >= opmlFeedsMax {
+			want = ErrImportLimit
+		}
+		if !errors.Is(r.Err, want) {
+			t.Fatalf("want err=%v for feed %d, got err=%v", want, n, r.Err)
+		}
+	}
+
+	summary := renderImport(results)
+	if !strings.Contains(summary, "so I skipped the last 5.") {
+		t.Fatalf("want the summary to say feeds were skipped, got %q", summary)
+	}
+}
+
+func TestBotImportTimeout(t *testing.T) {
+	b := newTestBot(NewMemoryStores(), http.DefaultClient)
+
+	feeds := []opmlFeed{
+		{Collection: "honk", Link: "ftp://example.com/feed"},
+		{Collection: "other", Link: "ftp://example.com/other"},
+	}
+
+	// Once the deadline has passed no more feeds are started, and the
+	// ones left over are reported rather than lost.
+	channel := &discordgo.Channel{ID: "channel1", Type: discordgo.ChannelTypeGuildText}
+	results := b.importFeeds("server1", channel, feeds, time.Now().Add(-time.Second))
+
+	for n, r := range results {
+		if r.Feed != feeds[n] || !errors.Is(r.Err, ErrImportTimeout) {
+			t.Fatalf("want feed %d not reached, got %+v", n, r)
+		}
+	}
+
+	summary := renderImport(results)
+	if !strings.Contains(summary, "before getting to 2 feeds") || !strings.Contains(summary, "not reached in time") {
+		t.Fatalf("want the summary to say which feeds weren't reached, got %q", summary)
+	}
+}
+
+func TestRenderImportTruncates(t *testing.T) {
+	var results []importResult
+	for n := 0; n < 100; n++ {
+		results = append(results, importResult{Feed: opmlFeed{Collection: "honk", Link: "http://example.com/" + strings.Repeat("a", 50)}})
+	}
+
+	summary := renderImport(results)
+	if len(summary) > messageContentLimit {
+		t.Fatalf("want at most %d characters, got %d", messageContentLimit, len(summary))
+	}
+
+	if !strings.HasSuffix(summary, "more.") {
+		t.Fatalf("want the summary to say how many feeds were left out, got %q", summary)
+	}
+}
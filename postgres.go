@@ -1,3 +1,28 @@
 package main
 
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
 const uniqueViolation = "23505"
+
+// NewPostgresStores returns stores backed by a PostgreSQL database.
+func NewPostgresStores(db *sql.DB) Stores {
+	return Stores{
+		Feeds:         &Feeds{DB: db},
+		Articles:      &Articles{db: db},
+		Collections:   &Collections{db: db},
+		Subscriptions: &Subscriptions{db: db},
+		Filters:       &Filters{db: db},
+		Deliveries:    &Deliveries{db: db, dialect: dialectPostgres},
+		Leases:        &Leases{db: db, dialect: dialectPostgres},
+	}
+}
+
+func isPostgresUniqueViolation(err error) bool {
+	var pqerr *pq.Error
+	return errors.As(err, &pqerr) && pqerr.Code == uniqueViolation
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/exp/slog"
+)
+
+// Discord only lets a bot identify on the gateway once every five
+// seconds, so shards are connected one at a time.
+const shardIdentifyDelay = 5 * time.Second
+
+var ErrInvalidShards = errors.New("invalid shard configuration")
+
+// Shards is the set of gateway sessions run by this process. Discord
+// sends each guild's events to exactly one shard, picked by shardForGuild,
+// so several goose processes can split the shards between them.
+type Shards struct {
+	Count    int
+	Sessions map[int]*discordgo.Session
+}
+
+// NewShards creates a session for each of the shard IDs out of count
+// shards in total. An empty ids runs every shard. A count of zero asks
+// Discord how many shards the bot should use.
+func NewShards(token string, count int, ids []int) (*Shards, error) {
+	if count <= 0 {
+		session, err := discordgo.New(token)
+		if err != nil {
+			return nil, err
+		}
+
+		gateway, err := session.GatewayBot()
+		if err != nil {
+			return nil, fmt.Errorf("fetch recommended shard count: %w", err)
+		}
+
+		count = gateway.Shards
+		if count <= 0 {
+			count = 1
+		}
+	}
+
+	if len(ids) == 0 {
+		for id := 0; id < count; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	shards := &Shards{
+		Count:    count,
+		Sessions: make(map[int]*discordgo.Session),
+	}
+
+	for _, id := range ids {
+		if id < 0 || id >= count {
+			return nil, fmt.Errorf("%w: shard %d out of %d", ErrInvalidShards, id, count)
+		}
+
+		session, err := discordgo.New(token)
+		if err != nil {
+			return nil, err
+		}
+		session.ShardID = id
+		session.ShardCount = count
+
+		shards.Sessions[id] = session
+	}
+
+	return shards, nil
+}
+
+// AddHandler adds an event handler to every session.
+func (s *Shards) AddHandler(handler interface{}) {
+	for _, session := range s.Sessions {
+		session.AddHandler(handler)
+	}
+}
+
+// Open connects every session to the gateway.
+func (s *Shards) Open() error {
+	for i, id := range s.ids() {
+		if i > 0 {
+			time.Sleep(shardIdentifyDelay)
+		}
+
+		err := s.Sessions[id].Open()
+		if err != nil {
+			return fmt.Errorf("open shard %d: %w", id, err)
+		}
+
+		slog.With(slog.Int("shard_id", id), slog.Int("shard_count", s.Count)).Info("Connected shard")
+	}
+
+	return nil
+}
+
+// Close disconnects every session from the gateway.
+func (s *Shards) Close() {
+	for id, session := range s.Sessions {
+		err := session.Close()
+		if err != nil {
+			slog.With(slog.Int("shard_id", id), slog.Any("err", err)).Warn("close shard")
+		}
+	}
+}
+
+// Any returns one of the sessions, for requests that aren't about a
+// particular guild.
+func (s *Shards) Any() *discordgo.Session {
+	return s.Sessions[s.ids()[0]]
+}
+
+// ForGuild returns the session responsible for guildID. REST requests
+// work from any session, so if another process runs that shard one of
+// this process's sessions is used instead.
+func (s *Shards) ForGuild(guildID string) *discordgo.Session {
+	if session, ok := s.Sessions[shardForGuild(guildID, s.Count)]; ok {
+		return session
+	}
+	return s.Any()
+}
+
+func (s *Shards) ids() []int {
+	var ids []int
+	for id := 0; id < s.Count; id++ {
+		if _, ok := s.Sessions[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// shardForGuild returns the shard that Discord sends guildID's events to.
+func shardForGuild(guildID string, count int) int {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil || count <= 1 {
+		return 0
+	}
+
+	return int((id >> 22) % uint64(count))
+}
+
+// parseShardIDs parses a comma-separated list of shard IDs and ranges,
+// e.g. "0-3,8".
+func parseShardIDs(s string) ([]int, error) {
+	var ids []int
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		first, last, isRange := strings.Cut(part, "-")
+
+		lo, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidShards, part)
+		}
+
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidShards, part)
+			}
+		}
+
+		for id := lo; id <= hi; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShardForGuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		guildID string
+		count   int
+		want    int
+	}{
+		{name: "single shard", guildID: "197038439483310086", count: 1, want: 0},
+		{name: "many shards", guildID: "197038439483310086", count: 16, want: (197038439483310086 >> 22) % 16},
+		{name: "small id", guildID: "4194304", count: 2, want: 1},
+		{name: "not a snowflake", guildID: "nope", count: 4, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shardForGuild(tt.guildID, tt.count)
+			if got != tt.want {
+				t.Errorf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseShardIDs(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int
+		wantErr error
+	}{
+		{input: "", want: nil},
+		{input: "3", want: []int{3}},
+		{input: "0, 2,4", want: []int{0, 2, 4}},
+		{input: "0-3,8", want: []int{0, 1, 2, 3, 8}},
+		{input: "3-1", wantErr: ErrInvalidShards},
+		{input: "one", wantErr: ErrInvalidShards},
+		{input: "1,", wantErr: ErrInvalidShards},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseShardIDs(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want err=%v, got err=%v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestShardsForGuild(t *testing.T) {
+	shards, err := NewShards("Bot token", 2, []int{1})
+	if err != nil {
+		t.Fatalf("NewShards: %v", err)
+	}
+
+	session := shards.Sessions[1]
+	if session.ShardID != 1 || session.ShardCount != 2 {
+		t.Fatalf("want shard 1 of 2, got shard %d of %d", session.ShardID, session.ShardCount)
+	}
+
+	// Guild 4194304 belongs to shard 1 and guild 1 to shard 0, which
+	// another process runs, so both go through the only session here.
+	for _, guildID := range []string{"4194304", "1"} {
+		if got := shards.ForGuild(guildID); got != session {
+			t.Errorf("want shard 1 for guild %s, got shard %d", guildID, got.ShardID)
+		}
+	}
+
+	_, err = NewShards("Bot token", 2, []int{2})
+	if !errors.Is(err, ErrInvalidShards) {
+		t.Fatalf("want err=%v, got err=%v", ErrInvalidShards, err)
+	}
+}
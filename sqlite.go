@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const sqliteScheme = "sqlite:"
+
+// sqlitePragmas are applied to every connection. Foreign keys are off by
+// default in SQLite, and the subscription cascades rely on them.
+var sqlitePragmas = []string{
+	"_pragma=foreign_keys(1)",
+	"_pragma=busy_timeout(5000)",
+	"_pragma=journal_mode(WAL)",
+	// Timestamps are compared as text, which only sorts correctly when
+	// they're all written the same way.
+	"_time_format=sqlite",
+}
+
+// NewSQLiteStores returns stores backed by a SQLite database.
+func NewSQLiteStores(db *sql.DB) Stores {
+	return Stores{
+		Feeds:         &Feeds{DB: db},
+		Articles:      &Articles{db: db},
+		Collections:   &Collections{db: db},
+		Subscriptions: &Subscriptions{db: db},
+		Filters:       &Filters{db: db},
+		Deliveries:    &Deliveries{db: db, dialect: dialectSQLite},
+		Leases:        &Leases{db: db, dialect: dialectSQLite},
+	}
+}
+
+// openSQLite opens the database file named by a DSN like
+// "sqlite:///var/lib/goose/goose.db" or "sqlite://goose.db".
+func openSQLite(dsn string) (*sql.DB, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(dsn, sqliteScheme), "//")
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+sep+strings.Join(sqlitePragmas, "&"))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows one writer at a time, so there's nothing to gain from
+	// more connections and a lot of "database is locked" errors to lose.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+
+	switch serr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return true
+	default:
+		return false
+	}
+}
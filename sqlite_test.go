@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// newSQLiteDB opens an empty SQLite database that is removed when the test
+// is done.
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := openSQLite("sqlite://" + filepath.Join(t.TempDir(), "goose.db"))
+	if err != nil {
+		t.Fatalf("Open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestSQLiteStores(t *testing.T) {
+	testStores(t, func(t *testing.T) Stores {
+		db := newSQLiteDB(t)
+
+		migrator, err := NewMigrator(db, dialectSQLite)
+		if err != nil {
+			t.Fatalf("NewMigrator: %v", err)
+		}
+
+		err = migrator.Up(context.Background())
+		if err != nil {
+			t.Fatalf("Up migrations: %v", err)
+		}
+
+		return NewSQLiteStores(db)
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// dialect is the flavour of SQL spoken by a database.
+type dialect int
+
+const (
+	dialectPostgres dialect = iota
+	dialectSQLite
+)
+
+func (d dialect) String() string {
+	if d == dialectSQLite {
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// FeedStore persists the feeds that goose crawls.
+type FeedStore interface {
+	Create(link *url.URL, notUntil time.Time) (*Feed, error)
+	ListReady(readyAfter time.Time) ([]Feed, error)
+	Get(id int64) (*Feed, error)
+	GetByLink(link string) (*Feed, error)
+	Update(feed *Feed) error
+	Delete(id int64) error
+}
+
+// ArticleStore persists the items found on feeds.
+type ArticleStore interface {
+	Create(article Article) (*Article, error)
+	Update(article Article) (bool, error)
+	Latest(feedID int64) (*Article, error)
+	Hashes(feedID int64) (map[string]string, error)
+}
+
+// CollectionStore persists the named groups of feeds in each server.
+type CollectionStore interface {
+	Create(collection Collection) (*Collection, error)
+	Get(id int64) (*Collection, error)
+	GetByName(serverID, name string) (*Collection, error)
+	Names(serverID string) ([]string, error)
+	ListByServer(serverID string) ([]Collection, error)
+	UpdateTemplate(id int64, template string) error
+	UpdateChannel(id int64, channelID string, channelType discordgo.ChannelType, crosspost bool) error
+	SetUpdateMode(id int64, mode UpdateMode) error
+	Rename(id int64, name string) error
+	Delete(id int64) error
+}
+
+// SubscriptionStore persists which feeds belong to which collections.
+type SubscriptionStore interface {
+	Create(subscription Subscription) (*Subscription, error)
+	UpdateLastPubDate(id int64, lastPubDate time.Time) error
+	ListByCollection(collectionID int64) ([]Subscription, error)
+	ListByFeed(feedID int64) ([]Subscription, error)
+	Delete(id int64) error
+}
+
+// FilterStore persists the filters on collections.
+type FilterStore interface {
+	Create(filter Filter) (*Filter, error)
+	ListByCollection(collectionID int64) ([]Filter, error)
+	Delete(collectionID, id int64) error
+}
+
+// DeliveryStore is the outbound queue of announcements.
+type DeliveryStore interface {
+	Claim(now time.Time, lease time.Duration, limit int) ([]Notification, error)
+	Sent(id int64, channelID, messageID string) error
+	Skip(id int64) error
+	Retry(id int64, cause error, notBefore time.Time) error
+	Fail(id int64, cause error) error
+}
+
+// LeaseStore persists named locks that expire unless they're renewed.
+type LeaseStore interface {
+	Acquire(name, holder string, ttl time.Duration) (bool, error)
+	Release(name, holder string) error
+}
+
+// Stores is everything goose keeps track of.
+type Stores struct {
+	Feeds         FeedStore
+	Articles      ArticleStore
+	Collections   CollectionStore
+	Subscriptions SubscriptionStore
+	Filters       FilterStore
+	Deliveries    DeliveryStore
+	Leases        LeaseStore
+}
+
+// OpenDatabase connects to the database named by dsn. DSNs starting with
+// "sqlite:" open a SQLite database file, and anything else is handed to
+// PostgreSQL.
+func OpenDatabase(dsn string) (*sql.DB, dialect, error) {
+	if strings.HasPrefix(dsn, sqliteScheme) {
+		db, err := openSQLite(dsn)
+		if err != nil {
+			return nil, dialectSQLite, fmt.Errorf("open sqlite: %w", err)
+		}
+		return db, dialectSQLite, nil
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, dialectPostgres, fmt.Errorf("open postgres: %w", err)
+	}
+	return db, dialectPostgres, nil
+}
+
+// NewStores returns stores backed by db.
+func NewStores(db *sql.DB, d dialect) Stores {
+	if d == dialectSQLite {
+		return NewSQLiteStores(db)
+	}
+	return NewPostgresStores(db)
+}
+
+// isUniqueViolation reports whether err means a row couldn't be written
+// because it would duplicate another one.
+func isUniqueViolation(err error) bool {
+	return isPostgresUniqueViolation(err) || isSQLiteUniqueViolation(err)
+}
@@ -0,0 +1,1010 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testStores checks that a set of stores behaves the way the bot expects.
+// Every storage backend has to pass it. newStores is called at the start
+// of each subtest and must return empty stores.
+func testStores(t *testing.T, newStores func(t *testing.T) Stores) {
+	t.Run("Feeds", func(t *testing.T) {
+		stores := newStores(t)
+
+		feeds := stores.Feeds
+
+		// There shouldn't be any ready feeds
+		ready, err := feeds.ListReady(time.Date(5000, 0, 0, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Errorf("ListReady: %v", err)
+			return
+		}
+
+		if len(ready) != 0 {
+			t.Errorf("There should be zero ready feeds in a brand-new DB [%v]", ready)
+			return
+		}
+
+		link, err := url.Parse("http://example.com?rss")
+		if err != nil {
+			t.Errorf("Failed to parse test URL: %v", err)
+			return
+		}
+
+		notUntil1 := time.Date(2023, 2, 2, 2, 2, 2, 2, time.UTC)
+
+		// Create a well-known good feed for testing.
+
+		feed1, err := feeds.Create(link, notUntil1)
+		if err != nil {
+			t.Errorf("Unexpected err when creating first feed: %v", err)
+			return
+		}
+
+		if feed1.Link != "http://example.com?rss" {
+			t.Errorf("Want Link=%q, got Link=%q", "http://example.com?rss", feed1.Link)
+			return
+		}
+
+		// Test that we can't add a duplicate feed.
+		_, err = feeds.Create(link, notUntil1)
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("Want err=%v, got err=%v", ErrAlreadyExists, err)
+			return
+		}
+
+		// OK, now that the database has a feed, punch in a date that is *after*
+		// the feed's NotUntil time with the expectation that it is returned in
+		// the list of ready feeds.
+		ready, err = feeds.ListReady(time.Date(2023, 3, 3, 3, 3, 3, 3, time.UTC))
+		if err != nil {
+			t.Errorf("ListReady after inserting one: %v", err)
+			return
+		}
+
+		if len(ready) != 1 {
+			t.Errorf("Expected slice of len=1, got len=%d", len(ready))
+			return
+		}
+
+		if *feed1 != ready[0] {
+			t.Errorf("Want feed [%+v], got [%+v]", *feed1, ready[0])
+			return
+		}
+
+		// Test the negative case for fetching a feed that does not
+		// exist.
+		_, err = feeds.GetByLink("http://does-not-exist.test")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Want err=%v, got err=%v", ErrNotFound, err)
+			return
+		}
+
+		// Now let's fetch the feed that was previously created.
+		fetched1, err := feeds.GetByLink(feed1.Link)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when fetching pre-existing feed", nil, err)
+			return
+		}
+
+		if *feed1 != *fetched1 {
+			t.Errorf("Want feed [%+v], got feed [%+v]", *feed1, *fetched1)
+			return
+		}
+
+		// And test that we can update it.
+		updated := &Feed{
+			ID:             feed1.ID,
+			Link:           "http://a-brand-new-link.test",
+			Title:          "A Brand New Feed",
+			NotUntil:       feed1.NotUntil,
+			NotUntilReason: cacheReasonMaxAge,
+			ETag:           `W/"5e15153d-120f"`,
+			LastModified:   "Wed, 21 Oct 2015 07:28:00 GMT",
+		}
+
+		err = feeds.Update(updated)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when updating feed", nil, err)
+			return
+		}
+
+		// Fetch it from the database to confirm we get the updated values.
+
+		fetchedUpdated, err := feeds.GetByLink(updated.Link)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when fetching updated feed", nil, err)
+			return
+		}
+
+		if *fetchedUpdated != *updated {
+			t.Errorf("Want updated feed [%+v], got [%+v]", *updated, *fetchedUpdated)
+			return
+		}
+
+		// Assert that the updated feed is returned in the list of ready feeds.
+		ready, err = feeds.ListReady(time.Date(2023, 3, 3, 3, 3, 3, 3, time.UTC))
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when listing ready feeds", nil, err)
+			return
+		}
+
+		if len(ready) != 1 {
+			t.Errorf("Expected slice of len=1, got len=%d", len(ready))
+			return
+		}
+
+		if *updated != ready[0] {
+			t.Errorf("Want feed [%+v], got [%+v]", *feed1, ready[0])
+			return
+		}
+
+		// Record a run of failures and disable the feed, which should
+		// take it out of the list of ready feeds.
+		updated.ConsecutiveFailures = 3
+		updated.LastError = "service unavailable"
+		updated.Disabled = true
+
+		err = feeds.Update(updated)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when disabling feed", nil, err)
+			return
+		}
+
+		fetchedDisabled, err := feeds.GetByLink(updated.Link)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when fetching disabled feed", nil, err)
+			return
+		}
+
+		if *fetchedDisabled != *updated {
+			t.Errorf("Want disabled feed [%+v], got [%+v]", *updated, *fetchedDisabled)
+			return
+		}
+
+		ready, err = feeds.ListReady(time.Date(2023, 3, 3, 3, 3, 3, 3, time.UTC))
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when listing ready feeds", nil, err)
+			return
+		}
+
+		if len(ready) != 0 {
+			t.Errorf("Expected disabled feed to be excluded, got [%+v]", ready)
+			return
+		}
+
+		// Now let's test deleting the feeds.
+
+		err = feeds.Delete(updated.ID)
+		if err != nil {
+			t.Errorf("Want err=%v, got err=%v when deleting the only feed", nil, err)
+			return
+		}
+
+		// And assert that the feed is gone because we've just deleted
+		// the only feed that was added.
+
+		_, err = feeds.GetByLink(updated.Link)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Want err=%v, got err=%v when fetching deleted feed", ErrNotFound, err)
+			return
+		}
+	})
+
+	t.Run("Collections", func(t *testing.T) {
+		stores := newStores(t)
+
+		collections := stores.Collections
+
+		c1, err := collections.Create(Collection{ServerID: "server1", Name: "collection1", ChannelID: "channel1", ChannelType: discordgo.ChannelTypeGuildNews, Crosspost: true})
+		if err != nil {
+			t.Fatalf("collections.Create: %v", err)
+		}
+		defer collections.Delete(c1.ID)
+
+		want := Collection{ID: c1.ID, ServerID: "server1", Name: "collection1", ChannelID: "channel1", ChannelType: discordgo.ChannelTypeGuildNews, Crosspost: true, UpdateMode: UpdateModeOff}
+		if *c1 != want {
+			t.Fatalf("want Collection [%+v], got Collection [%+v]", want, *c1)
+		}
+
+		_, err = collections.Create(Collection{ServerID: "server1", Name: "collection1", ChannelID: "channel9"})
+		if !errors.Is(err, ErrCollectionNameTaken) {
+			t.Fatalf("want err=%v, got err=%v when reusing a collection name", ErrCollectionNameTaken, err)
+		}
+
+		other, err := collections.Create(Collection{ServerID: "server2", Name: "collection1", ChannelID: "channel1"})
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when reusing a collection name in another server", err)
+		}
+		defer collections.Delete(other.ID)
+
+		fetch1, err := collections.GetByName("server1", "collection1")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when fetching collection by name", err)
+		}
+
+		if *fetch1 != *c1 {
+			t.Fatalf("want Collection [%+v], got Collection [%+v]", *c1, *fetch1)
+		}
+
+		_, err = collections.GetByName("server1", "does not exist")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when fetching non-existent collection", ErrNotFound, err)
+		}
+
+		err = collections.UpdateTemplate(c1.ID, "{{.Title}} {{.Link}}")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when updating template", err)
+		}
+		c1.Template = "{{.Title}} {{.Link}}"
+
+		fetch1, err = collections.Get(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when fetching collection", err)
+		}
+
+		if *fetch1 != *c1 {
+			t.Fatalf("want Collection [%+v], got Collection [%+v]", *c1, *fetch1)
+		}
+
+		c2, err := collections.Create(Collection{ServerID: "server1", Name: "a collection", ChannelID: "channel2"})
+		if err != nil {
+			t.Fatalf("collections.Create: %v", err)
+		}
+		defer collections.Delete(c2.ID)
+
+		byServer, err := collections.ListByServer("server1")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing collections by server", err)
+		}
+
+		if want := []Collection{*c2, *c1}; !reflect.DeepEqual(want, byServer) {
+			t.Fatalf("want Collections [%+v], got [%+v]", want, byServer)
+		}
+
+		names, err := collections.Names("server1")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing collection names", err)
+		}
+
+		sort.Strings(names)
+		if want := []string{"a collection", "collection1"}; !reflect.DeepEqual(want, names) {
+			t.Fatalf("want names %q, got %q", want, names)
+		}
+
+		err = collections.Rename(c2.ID, "collection1")
+		if !errors.Is(err, ErrCollectionNameTaken) {
+			t.Fatalf("want err=%v, got err=%v when renaming to a collection name in use", ErrCollectionNameTaken, err)
+		}
+
+		err = collections.UpdateChannel(c2.ID, "channel3", discordgo.ChannelTypeGuildForum, false)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when moving collection", err)
+		}
+
+		err = collections.SetUpdateMode(c2.ID, UpdateModeEdit)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when setting update mode", err)
+		}
+
+		err = collections.Rename(c2.ID, "renamed")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when renaming collection", err)
+		}
+
+		moved, err := collections.GetByName("server1", "renamed")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when fetching renamed collection", err)
+		}
+
+		want = *c2
+		want.ChannelID = "channel3"
+		want.ChannelType = discordgo.ChannelTypeGuildForum
+		want.Name = "renamed"
+		want.UpdateMode = UpdateModeEdit
+		if *moved != want {
+			t.Fatalf("want Collection [%+v], got Collection [%+v]", want, *moved)
+		}
+
+		err = collections.UpdateChannel(-1, "channel4", discordgo.ChannelTypeGuildText, false)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when moving non-existent collection", ErrNotFound, err)
+		}
+
+		err = collections.Rename(-1, "renamed")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when renaming non-existent collection", ErrNotFound, err)
+		}
+
+		err = collections.SetUpdateMode(-1, UpdateModeNotice)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when setting update mode of non-existent collection", ErrNotFound, err)
+		}
+
+		err = collections.Delete(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil> got err=%v when deleting Collection", err)
+		}
+
+		_, err = collections.GetByName("server1", "collection1")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when fetching deleted collection", ErrNotFound, err)
+		}
+	})
+
+	t.Run("Subscriptions", func(t *testing.T) {
+		stores := newStores(t)
+
+		feeds := stores.Feeds
+		collections := stores.Collections
+		subscriptions := stores.Subscriptions
+
+		u1, err := url.Parse("http://another.example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://another.example.com?rss", err)
+		}
+
+		u2, err := url.Parse("http://yet-another.example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://yet-another.example.com?rss", err)
+		}
+
+		feed1, err := feeds.Create(u1, time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC))
+		if err != nil {
+			t.Fatalf("feeds.Create: %v", err)
+		}
+		defer feeds.Delete(feed1.ID)
+
+		feed2, err := feeds.Create(u2, time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC))
+		if err != nil {
+			t.Fatalf("feeds.Create: %v", err)
+		}
+		defer feeds.Delete(feed2.ID)
+
+		c1, err := collections.Create(Collection{ServerID: "server1", Name: "collection1", ChannelID: "channel1"})
+		if err != nil {
+			t.Fatalf("collections.Create: %v", err)
+		}
+		defer collections.Delete(c1.ID)
+
+		sub1, err := subscriptions.Create(Subscription{CollectionID: c1.ID, FeedID: feed1.ID, LastPubDate: time.Date(2, 2, 2, 2, 2, 2, 2, time.UTC)})
+		if err != nil {
+			t.Fatalf("subscriptions.Create: %v", err)
+		}
+		defer subscriptions.Delete(sub1.ID)
+
+		if sub1.CollectionID != c1.ID {
+			t.Fatalf("want CollectionID=%d, got CollectionID=%d", c1.ID, sub1.CollectionID)
+		}
+
+		if sub1.FeedID != feed1.ID {
+			t.Fatalf("want FeedID=%d, got FeedID=%d", feed1.ID, sub1.FeedID)
+		}
+
+		_, err = subscriptions.Create(Subscription{CollectionID: c1.ID, FeedID: feed1.ID, LastPubDate: time.Date(2, 2, 2, 2, 2, 2, 2, time.UTC)})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("want err=%v, got err=%v when adding a feed to a collection twice", ErrAlreadyExists, err)
+		}
+
+		sub2, err := subscriptions.Create(Subscription{CollectionID: c1.ID, FeedID: feed2.ID, LastPubDate: time.Date(2, 2, 2, 2, 2, 2, 2, time.UTC)})
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when adding a second feed to a collection", err)
+		}
+		defer subscriptions.Delete(sub2.ID)
+
+		byCollection, err := subscriptions.ListByCollection(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing subscriptions by collection", err)
+		}
+
+		if want := []Subscription{*sub1, *sub2}; !reflect.DeepEqual(want, byCollection) {
+			t.Fatalf("want Subscriptions [%+v], got [%+v]", want, byCollection)
+		}
+
+		byFeed, err := subscriptions.ListByFeed(feed1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing subscriptions by feed", err)
+		}
+
+		if len(byFeed) != 1 || byFeed[0] != *sub1 {
+			t.Fatalf("want Subscriptions [%+v], got [%+v]", []Subscription{*sub1}, byFeed)
+		}
+
+		err = subscriptions.Delete(sub2.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil> got err=%v when deleting Subscription", err)
+		}
+
+		byCollection, err = subscriptions.ListByCollection(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing subscriptions by collection", err)
+		}
+
+		if len(byCollection) != 1 || byCollection[0] != *sub1 {
+			t.Fatalf("want Subscriptions [%+v], got [%+v]", []Subscription{*sub1}, byCollection)
+		}
+
+		// Deleting the collection takes its subscriptions with it.
+		err = collections.Delete(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil> got err=%v when deleting Collection", err)
+		}
+
+		byFeed, err = subscriptions.ListByFeed(feed1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing subscriptions by feed", err)
+		}
+
+		if len(byFeed) != 0 {
+			t.Fatalf("want no subscriptions after deleting the collection, got [%+v]", byFeed)
+		}
+	})
+
+	t.Run("Articles", func(t *testing.T) {
+		stores := newStores(t)
+
+		feeds := stores.Feeds
+		articles := stores.Articles
+
+		u, err := url.Parse("http://another.example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://another.example.com?rss", err)
+		}
+
+		feed1, err := feeds.Create(u, time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC))
+		if err != nil {
+			t.Fatalf("feeds.Create: %v", err)
+		}
+
+		first := Article{
+			FeedID:      feed1.ID,
+			Title:       "The First Amazing Article",
+			Link:        "http://another.example.com/article?id=1",
+			Published:   time.Date(4, 4, 4, 4, 4, 4, 4, time.UTC),
+			Author:      "Mother Goose",
+			Description: "Honk honk honk.",
+			ImageURL:    "http://another.example.com/goose.png",
+			Categories:  []string{"geese", "ponds"},
+			DateSource:  DateSourceUpdated,
+			FirstSeen:   time.Date(4, 4, 5, 4, 4, 4, 4, time.UTC),
+			ContentHash: "hash1",
+			Updated:     time.Date(4, 4, 6, 4, 4, 4, 0, time.UTC),
+			MediaURL:    "http://another.example.com/honk.mp3",
+			MediaType:   "audio/mpeg",
+			Episode:     "12",
+			Season:      "2",
+			Duration:    42 * time.Minute,
+		}
+
+		art1, err := articles.Create(first)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when creating first article", err)
+		}
+
+		if art1.Author != first.Author || art1.Description != first.Description || art1.ImageURL != first.ImageURL || !reflect.DeepEqual(art1.Categories, first.Categories) {
+			t.Fatalf("want article details [%+v], got [%+v]", first, *art1)
+		}
+
+		if art1.DateSource != first.DateSource || !art1.FirstSeen.Equal(first.FirstSeen) {
+			t.Fatalf("want DateSource=%q FirstSeen=%v, got DateSource=%q FirstSeen=%v", first.DateSource, first.FirstSeen, art1.DateSource, art1.FirstSeen)
+		}
+
+		if art1.ContentHash != first.ContentHash || !art1.Updated.Equal(first.Updated) {
+			t.Fatalf("want ContentHash=%q Updated=%v, got ContentHash=%q Updated=%v", first.ContentHash, first.Updated, art1.ContentHash, art1.Updated)
+		}
+
+		if art1.MediaURL != first.MediaURL || art1.MediaType != first.MediaType || art1.Episode != first.Episode || art1.Season != first.Season || art1.Duration != first.Duration {
+			t.Fatalf("want media details [%+v], got [%+v]", first, *art1)
+		}
+
+		_, err = articles.Create(first)
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("want err=%v, got err=%v when creating duplicate article", ErrAlreadyExists, err)
+		}
+
+		// Feeds change how they link to an item without it becoming a
+		// new item.
+		tracked := first
+		tracked.Link = "http://another.example.com/article?id=1&utm_source=rss"
+		_, err = articles.Create(tracked)
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("want err=%v, got err=%v when creating an article with tracking parameters", ErrAlreadyExists, err)
+		}
+
+		u2, err := url.Parse("http://mirror.example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://mirror.example.com?rss", err)
+		}
+
+		feed2, err := feeds.Create(u2, time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC))
+		if err != nil {
+			t.Fatalf("feeds.Create: %v", err)
+		}
+
+		mirrored := first
+		mirrored.FeedID = feed2.ID
+		_, err = articles.Create(mirrored)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when another feed links to the same article", err)
+		}
+
+		latest, err := articles.Latest(feed1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when getting latest article for feed", err)
+		}
+
+		if !reflect.DeepEqual(*latest, *art1) {
+			t.Fatalf("want latest article [%+v], got article [%+v]", *art1, *latest)
+		}
+
+		art2, err := articles.Create(Article{
+			FeedID:    feed1.ID,
+			GUID:      "tag:another.example.com,2023:12",
+			Title:     "The next best article",
+			Link:      "http://another.example.com/article?id=12",
+			Published: time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC),
+		})
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when creating the second article", err)
+		}
+
+		_, err = articles.Create(Article{
+			FeedID:    feed1.ID,
+			GUID:      "tag:another.example.com,2023:12",
+			Title:     "The next best article, moved",
+			Link:      "http://another.example.com/moved",
+			Published: time.Date(5, 5, 5, 5, 5, 5, 5, time.UTC),
+		})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("want err=%v, got err=%v when an article with the same GUID moves", ErrAlreadyExists, err)
+		}
+
+		latest, err = articles.Latest(feed1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when getting the latest article agains", err)
+		}
+
+		if !reflect.DeepEqual(*latest, *art2) {
+			t.Fatalf("want latest Article [%+v], got Article [%+v]", *art2, *latest)
+		}
+
+		if art2.DateSource != DateSourcePublished || art2.FirstSeen.IsZero() {
+			t.Fatalf("want an article dated by its publication date and first seen now, got [%+v]", *art2)
+		}
+
+		// Undated items seen in the same crawl share a date, and the one
+		// that was added last counts as the latest.
+		seen := time.Date(6, 6, 6, 6, 6, 6, 6, time.UTC)
+		for _, link := range []string{"http://another.example.com/undated?id=1", "http://another.example.com/undated?id=2"} {
+			_, err := articles.Create(Article{FeedID: feed1.ID, Title: "Undated", Link: link, Published: seen, DateSource: DateSourceFirstSeen, FirstSeen: seen})
+			if err != nil {
+				t.Fatalf("want err=<nil>, got err=%v when creating an undated article", err)
+			}
+		}
+
+		latest, err = articles.Latest(feed1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when getting the latest undated article", err)
+		}
+
+		if latest.Link != "http://another.example.com/undated?id=2" || latest.DateSource != DateSourceFirstSeen {
+			t.Fatalf("want the last undated article to be the latest, got [%+v]", *latest)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		stores := newStores(t)
+
+		collections := stores.Collections
+		filters := stores.Filters
+
+		c1, err := collections.Create(Collection{ServerID: "server1", Name: "collection1", ChannelID: "channel1"})
+		if err != nil {
+			t.Fatalf("collections.Create: %v", err)
+		}
+
+		filter1, err := filters.Create(Filter{CollectionID: c1.ID, Field: filterFieldTitle, Mode: filterModeInclude, Kind: filterKindKeyword, Pattern: "goose"})
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when creating first filter", err)
+		}
+
+		filter2, err := filters.Create(Filter{CollectionID: c1.ID, Field: filterFieldAny, Mode: filterModeExclude, Kind: filterKindRegex, Pattern: `-rc\d+`})
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when creating second filter", err)
+		}
+
+		list, err := filters.ListByCollection(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing filters", err)
+		}
+
+		if want := []Filter{*filter1, *filter2}; !reflect.DeepEqual(want, list) {
+			t.Fatalf("want filters [%+v], got [%+v]", want, list)
+		}
+
+		err = filters.Delete(c1.ID, filter1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when deleting filter", err)
+		}
+
+		err = filters.Delete(c1.ID, filter1.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when deleting filter twice", ErrNotFound, err)
+		}
+
+		// Deleting the collection takes its filters with it.
+		err = collections.Delete(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when deleting collection", err)
+		}
+
+		list, err = filters.ListByCollection(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when listing filters", err)
+		}
+
+		if len(list) != 0 {
+			t.Fatalf("want no filters after deleting collection, got [%+v]", list)
+		}
+	})
+
+	t.Run("Leases", func(t *testing.T) {
+		stores := newStores(t)
+
+		// Leases expire by the store's own clock, so this takes a moment.
+		const ttl = 300 * time.Millisecond
+		leases := stores.Leases
+
+		ok, err := leases.Acquire("leader", "a", ttl)
+		if err != nil || !ok {
+			t.Fatalf("want ok=true err=<nil>, got ok=%v err=%v when acquiring free lease", ok, err)
+		}
+
+		ok, err = leases.Acquire("leader", "b", ttl)
+		if err != nil || ok {
+			t.Fatalf("want ok=false err=<nil>, got ok=%v err=%v when acquiring held lease", ok, err)
+		}
+
+		time.Sleep(ttl / 2)
+
+		ok, err = leases.Acquire("leader", "a", ttl)
+		if err != nil || !ok {
+			t.Fatalf("want ok=true err=<nil>, got ok=%v err=%v when renewing lease", ok, err)
+		}
+
+		// The renewal pushed the expiry out past the original one.
+		time.Sleep(ttl/2 + ttl/6)
+
+		ok, err = leases.Acquire("leader", "b", ttl)
+		if err != nil || ok {
+			t.Fatalf("want ok=false err=<nil>, got ok=%v err=%v when acquiring renewed lease", ok, err)
+		}
+
+		time.Sleep(ttl / 2)
+
+		ok, err = leases.Acquire("leader", "b", ttl)
+		if err != nil || !ok {
+			t.Fatalf("want ok=true err=<nil>, got ok=%v err=%v when acquiring expired lease", ok, err)
+		}
+
+		// Only the holder can release a lease.
+		err = leases.Release("leader", "a")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when releasing lease held by someone else", err)
+		}
+
+		ok, err = leases.Acquire("leader", "a", ttl)
+		if err != nil || ok {
+			t.Fatalf("want ok=false err=<nil>, got ok=%v err=%v after releasing lease held by someone else", ok, err)
+		}
+
+		err = leases.Release("leader", "b")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when releasing lease", err)
+		}
+
+		ok, err = leases.Acquire("leader", "a", ttl)
+		if err != nil || !ok {
+			t.Fatalf("want ok=true err=<nil>, got ok=%v err=%v when acquiring released lease", ok, err)
+		}
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		stores := newStores(t)
+
+		feeds := stores.Feeds
+		collections := stores.Collections
+		subscriptions := stores.Subscriptions
+		articles := stores.Articles
+		deliveries := stores.Deliveries
+
+		url1, err := url.Parse("http://example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://example.com?rss", err)
+		}
+		feed1, err := feeds.Create(url1, time.Time{})
+		if err != nil {
+			t.Fatalf("Create feed: %v", err)
+		}
+
+		c1, err := collections.Create(Collection{ServerID: "server1", Name: "collection1", ChannelID: "channel1"})
+		if err != nil {
+			t.Fatalf("Create first collection: %v", err)
+		}
+
+		c2, err := collections.Create(Collection{ServerID: "server2", Name: "collection2", ChannelID: "channel2", ChannelType: discordgo.ChannelTypeGuildForum})
+		if err != nil {
+			t.Fatalf("Create second collection: %v", err)
+		}
+
+		sub1, err := subscriptions.Create(Subscription{CollectionID: c1.ID, FeedID: feed1.ID})
+		if err != nil {
+			t.Fatalf("Create first subscription: %v", err)
+		}
+
+		sub2, err := subscriptions.Create(Subscription{CollectionID: c2.ID, FeedID: feed1.ID, LastPubDate: time.Time{}.AddDate(0, 0, 1)})
+		if err != nil {
+			t.Fatalf("Create second subscription: %v", err)
+		}
+
+		newArticles := []Article{
+			{FeedID: feed1.ID, Title: "A", Link: "http://example.com/A", Published: time.Time{}.AddDate(0, 0, 1)},
+			{FeedID: feed1.ID, Title: "B", Link: "http://example.com/B", Published: time.Time{}.AddDate(0, 0, 2)},
+			{FeedID: feed1.ID, Title: "C", Link: "http://example.com/C", Published: time.Time{}.AddDate(0, 0, 3)},
+		}
+
+		for _, a := range newArticles {
+			_, err = articles.Create(a)
+			if err != nil {
+				t.Fatalf("Create Article [%+v]: %v", a, err)
+			}
+		}
+
+		now := time.Now().UTC()
+
+		notifications, err := deliveries.Claim(now, time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming notifications", err)
+		}
+
+		type minifiedNotification struct {
+			CollectionID int64
+			ServerID     string
+			ChannelID    string
+			Link         string
+		}
+
+		outboxes := make(map[int64][]minifiedNotification)
+
+		for _, n := range notifications {
+			mini := minifiedNotification{
+				CollectionID: n.CollectionID,
+				ServerID:     n.ServerID,
+				ChannelID:    n.ChannelID,
+				Link:         n.Article.Link,
+			}
+			outboxes[n.SubscriptionID] = append(outboxes[n.SubscriptionID], mini)
+		}
+
+		want := map[int64][]minifiedNotification{
+			sub1.ID: {
+				{CollectionID: c1.ID, ServerID: "server1", ChannelID: "channel1", Link: "http://example.com/A"},
+				{CollectionID: c1.ID, ServerID: "server1", ChannelID: "channel1", Link: "http://example.com/B"},
+				{CollectionID: c1.ID, ServerID: "server1", ChannelID: "channel1", Link: "http://example.com/C"},
+			},
+			// A is announced even though the second subscription already
+			// announced something from the same day, since it's new.
+			sub2.ID: {
+				{CollectionID: c2.ID, ServerID: "server2", ChannelID: "channel2", Link: "http://example.com/A"},
+				{CollectionID: c2.ID, ServerID: "server2", ChannelID: "channel2", Link: "http://example.com/B"},
+				{CollectionID: c2.ID, ServerID: "server2", ChannelID: "channel2", Link: "http://example.com/C"},
+			},
+		}
+
+		if !reflect.DeepEqual(want, outboxes) {
+			t.Fatalf("want [%+v], got [%+v]", want, outboxes)
+		}
+
+		// Claimed notifications aren't handed out again while leased.
+		claimed, err := deliveries.Claim(now, time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming leased notifications", err)
+		}
+		if len(claimed) != 0 {
+			t.Fatalf("want no notifications while leased, got [%+v]", claimed)
+		}
+
+		err = deliveries.Sent(notifications[0].DeliveryID, notifications[0].ChannelID, "message1")
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when marking delivery sent", err)
+		}
+
+		err = deliveries.Skip(notifications[1].DeliveryID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when skipping delivery", err)
+		}
+
+		err = deliveries.Retry(notifications[2].DeliveryID, errors.New("try again"), now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when retrying delivery", err)
+		}
+
+		for _, n := range notifications[3:] {
+			err = deliveries.Fail(n.DeliveryID, errors.New("give up"))
+			if err != nil {
+				t.Fatalf("want err=<nil>, got err=%v when failing delivery", err)
+			}
+		}
+
+		// Only the retried delivery comes back, and only once it's due.
+		claimed, err = deliveries.Claim(now.Add(2*time.Minute), time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming notifications", err)
+		}
+		if len(claimed) != 0 {
+			t.Fatalf("want no notifications before retry is due, got [%+v]", claimed)
+		}
+
+		claimed, err = deliveries.Claim(now.Add(2*time.Hour), time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming notifications", err)
+		}
+		if len(claimed) != 1 || claimed[0].DeliveryID != notifications[2].DeliveryID || claimed[0].Attempts != 2 {
+			t.Fatalf("want retried delivery %d on its second attempt, got [%+v]", notifications[2].DeliveryID, claimed)
+		}
+
+		// Ingesting the same article again doesn't queue it twice.
+		_, err = articles.Create(newArticles[0])
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("want err=%v, got err=%v when creating duplicate article", ErrAlreadyExists, err)
+		}
+
+		err = subscriptions.UpdateLastPubDate(sub1.ID, newArticles[2].Published)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when updating last pub date", err)
+		}
+
+		err = subscriptions.UpdateLastPubDate(sub1.ID, newArticles[0].Published)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when updating last pub date", err)
+		}
+
+		fetch1, err := subscriptions.ListByCollection(c1.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when fetching subscription", err)
+		}
+		if len(fetch1) != 1 || !fetch1[0].LastPubDate.Equal(newArticles[2].Published) {
+			t.Fatalf("want LastPubDate=%v to never move backwards, got [%+v]", newArticles[2].Published, fetch1)
+		}
+	})
+	t.Run("Updates", func(t *testing.T) {
+		stores := newStores(t)
+
+		feeds := stores.Feeds
+		collections := stores.Collections
+		subscriptions := stores.Subscriptions
+		articles := stores.Articles
+		deliveries := stores.Deliveries
+
+		link, err := url.Parse("http://example.com?rss")
+		if err != nil {
+			t.Fatalf("url.Parse [%q]: %v", "http://example.com?rss", err)
+		}
+		feed, err := feeds.Create(link, time.Time{})
+		if err != nil {
+			t.Fatalf("Create feed: %v", err)
+		}
+
+		following, err := collections.Create(Collection{ServerID: "server1", Name: "following", ChannelID: "channel1", UpdateMode: UpdateModeEdit})
+		if err != nil {
+			t.Fatalf("Create following collection: %v", err)
+		}
+
+		ignoring, err := collections.Create(Collection{ServerID: "server1", Name: "ignoring", ChannelID: "channel2"})
+		if err != nil {
+			t.Fatalf("Create ignoring collection: %v", err)
+		}
+
+		for _, c := range []*Collection{following, ignoring} {
+			_, err = subscriptions.Create(Subscription{CollectionID: c.ID, FeedID: feed.ID})
+			if err != nil {
+				t.Fatalf("Create subscription: %v", err)
+			}
+		}
+
+		original := Article{FeedID: feed.ID, GUID: "honk-1", Title: "Goose spotted", Link: "http://example.com/1", Published: time.Time{}.AddDate(0, 0, 1), ContentHash: "v1"}
+		legacy := Article{FeedID: feed.ID, GUID: "honk-2", Title: "Goose gone", Link: "http://example.com/2", Published: time.Time{}.AddDate(0, 0, 2)}
+
+		for _, a := range []Article{original, legacy} {
+			_, err = articles.Create(a)
+			if err != nil {
+				t.Fatalf("Create Article [%+v]: %v", a, err)
+			}
+		}
+
+		now := time.Now().UTC()
+
+		notifications, err := deliveries.Claim(now, time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming notifications", err)
+		}
+		if len(notifications) != 4 {
+			t.Fatalf("want a notification per article and collection, got [%+v]", notifications)
+		}
+
+		for _, n := range notifications {
+			if n.Update {
+				t.Fatalf("want a new article to not be an update, got [%+v]", n)
+			}
+
+			err = deliveries.Sent(n.DeliveryID, "thread-"+n.ChannelID, n.CollectionName+"/"+n.Article.GUID)
+			if err != nil {
+				t.Fatalf("want err=<nil>, got err=%v when marking delivery sent", err)
+			}
+		}
+
+		_, err = articles.Update(Article{FeedID: feed.ID, GUID: "does not exist", ContentHash: "v1"})
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want err=%v, got err=%v when updating non-existent article", ErrNotFound, err)
+		}
+
+		changed, err := articles.Update(original)
+		if err != nil || changed {
+			t.Fatalf("want changed=false err=<nil>, got changed=%v err=%v when nothing changed", changed, err)
+		}
+
+		// Articles from before content was hashed take on a hash without
+		// being announced again.
+		legacy.ContentHash = "v1"
+		changed, err = articles.Update(legacy)
+		if err != nil || changed {
+			t.Fatalf("want changed=false err=<nil>, got changed=%v err=%v when hashing a legacy article", changed, err)
+		}
+
+		edited := original
+		edited.Title = "Two geese spotted"
+		edited.Published = time.Time{}.AddDate(0, 0, 5)
+		edited.ContentHash = "v2"
+		edited.Updated = time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC)
+
+		changed, err = articles.Update(edited)
+		if err != nil || !changed {
+			t.Fatalf("want changed=true err=<nil>, got changed=%v err=%v when the content changed", changed, err)
+		}
+
+		claimed, err := deliveries.Claim(now.Add(2*time.Minute), time.Minute, 100)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when claiming updates", err)
+		}
+
+		if len(claimed) != 1 {
+			t.Fatalf("want one update for the collection following updates, got [%+v]", claimed)
+		}
+
+		n := claimed[0]
+		if !n.Update || n.CollectionID != following.ID || n.UpdateMode != UpdateModeEdit || n.MessageID != "following/honk-1" || n.MessageChannel != "thread-channel1" || n.Attempts != 1 {
+			t.Fatalf("want an update of message %q in %q for collection %d, got [%+v]", "following/honk-1", "thread-channel1", following.ID, n)
+		}
+
+		if n.Article.Title != edited.Title || n.Article.ContentHash != edited.ContentHash || !n.Article.Updated.Equal(edited.Updated) || !n.Article.Published.Equal(original.Published) {
+			t.Fatalf("want the edited article with its original publication date, got [%+v]", n.Article)
+		}
+
+		hashes, err := articles.Hashes(feed.ID)
+		if err != nil {
+			t.Fatalf("want err=<nil>, got err=%v when loading content hashes", err)
+		}
+
+		wantHashes := map[string]string{"honk-1": "v2", "honk-2": "v1"}
+		if !reflect.DeepEqual(wantHashes, hashes) {
+			t.Fatalf("want content hashes %v, got %v", wantHashes, hashes)
+		}
+	})
+}
@@ -2,45 +2,66 @@ package main
 
 import (
 	"database/sql"
-	"errors"
 	"time"
 
-	"github.com/lib/pq"
+	"github.com/bwmarrin/discordgo"
 )
 
 type Notification struct {
+	DeliveryID     int64
+	Attempts       int
 	SubscriptionID int64
+	CollectionID   int64
 	ServerID       string
 	ChannelID      string
+	ChannelType    discordgo.ChannelType
+	Crosspost      bool
 	CollectionName string
-	ArticleID      int64
-	Title          string
-	Link           string
-	PubDate        time.Time
+	FeedTitle      string
+	Template       string
+	Article        Article
+
+	// Update is set when the article was already announced and has since
+	// changed. MessageID is the announcement and MessageChannel the channel
+	// it was made in, which is the post it started in forum channels and
+	// may no longer be the collection's. UpdateMode is how the collection
+	// wants to hear about the change.
+	Update         bool
+	MessageID      string
+	MessageChannel string
+	UpdateMode     UpdateMode
 }
 
+// Subscription is a feed's membership in a collection. It tracks what has
+// been announced from the feed, while the collection decides where and
+// how.
 type Subscription struct {
-	ID             int64
-	FeedID         int64
-	ServerID       string
-	ChannelID      string
-	CollectionName string
-	LastPubDate    time.Time
+	ID           int64
+	CollectionID int64
+	FeedID       int64
+	LastPubDate  time.Time
 }
 
 type Subscriptions struct {
 	db *sql.DB
 }
 
-func (s *Subscriptions) Create(feedID int64, serverID, channelID, collection string, lastPubDate time.Time) (*Subscription, error) {
-	stmt := `INSERT INTO subscriptions (feed_id, server_id, channel_id, collection_name, last_pub_date) VALUES ($1, $2, $3, $4, $5) RETURNING id, feed_id, server_id, channel_id, collection_name, last_pub_date`
-	args := []any{feedID, serverID, channelID, collection, lastPubDate}
+const subscriptionColumns = `id, collection_id, feed_id, last_pub_date`
+
+func scanSubscription(row rowScanner, sub *Subscription) error {
+	return row.Scan(&sub.ID, &sub.CollectionID, &sub.FeedID, &sub.LastPubDate)
+}
+
+// Create adds a feed to a collection. It returns ErrAlreadyExists if the
+// feed is already in the collection.
+func (s *Subscriptions) Create(subscription Subscription) (*Subscription, error) {
+	stmt := `INSERT INTO subscriptions (collection_id, feed_id, last_pub_date) VALUES ($1, $2, $3) RETURNING ` + subscriptionColumns
+	args := []any{subscription.CollectionID, subscription.FeedID, subscription.LastPubDate.UTC()}
 
 	var sub Subscription
-	var pqerr *pq.Error
 
-	err := s.db.QueryRow(stmt, args...).Scan(&sub.ID, &sub.FeedID, &sub.ServerID, &sub.ChannelID, &sub.CollectionName, &sub.LastPubDate)
-	if errors.As(err, &pqerr) && pqerr.Code == uniqueViolation {
+	err := scanSubscription(s.db.QueryRow(stmt, args...), &sub)
+	if isUniqueViolation(err) {
 		return nil, ErrAlreadyExists
 	}
 	if err != nil {
@@ -50,52 +71,50 @@ func (s *Subscriptions) Create(feedID int64, serverID, channelID, collection str
 	return &sub, nil
 }
 
+// UpdateLastPubDate records that an item published at lastPubDate was
+// announced. It never moves the date backwards, since retried deliveries
+// can finish out of order.
 func (s *Subscriptions) UpdateLastPubDate(id int64, lastPubDate time.Time) error {
-	stmt := `UPDATE subscriptions SET last_pub_date = $2 WHERE id = $1`
-	args := []any{id, lastPubDate}
+	stmt := `UPDATE subscriptions SET last_pub_date = $2 WHERE id = $1 AND (last_pub_date IS NULL OR last_pub_date < $2)`
+	args := []any{id, lastPubDate.UTC()}
 
 	_, err := s.db.Exec(stmt, args...)
 
 	return err
 }
 
-func (s *Subscriptions) GetByCollectionName(serverID, collectionName string) (*Subscription, error) {
-	stmt := `SELECT id, feed_id, server_id, channel_id, collection_name, last_pub_date FROM subscriptions WHERE server_id = $1 AND collection_name = $2`
-	args := []any{serverID, collectionName}
+func (s *Subscriptions) ListByCollection(collectionID int64) ([]Subscription, error) {
+	stmt := `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE collection_id = $1 ORDER BY id ASC`
+	args := []any{collectionID}
 
-	var sub Subscription
+	return s.list(stmt, args...)
+}
 
-	err := s.db.QueryRow(stmt, args...).Scan(&sub.ID, &sub.FeedID, &sub.ServerID, &sub.ChannelID, &sub.CollectionName, &sub.LastPubDate)
-	if errors.Is(err, sql.ErrNoRows) {
-		err = ErrNotFound
-	}
-	if err != nil {
-		return nil, err
-	}
+func (s *Subscriptions) ListByFeed(feedID int64) ([]Subscription, error) {
+	stmt := `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE feed_id = $1 ORDER BY id ASC`
+	args := []any{feedID}
 
-	return &sub, nil
+	return s.list(stmt, args...)
 }
 
-func (s *Subscriptions) GetCollectionNames(serverID string) ([]string, error) {
-	stmt := `SELECT collection_name FROM subscriptions WHERE server_id = $1`
-	args := []any{serverID}
-
+func (s *Subscriptions) list(stmt string, args ...any) ([]Subscription, error) {
 	rows, err := s.db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var collections []string
+	var subs []Subscription
 	for rows.Next() {
-		var collection string
-		if err := rows.Scan(&collection); err != nil {
+		var sub Subscription
+		err := scanSubscription(rows, &sub)
+		if err != nil {
 			return nil, err
 		}
-		collections = append(collections, collection)
+		subs = append(subs, sub)
 	}
 
-	return collections, nil
+	return subs, nil
 }
 
 func (s *Subscriptions) Delete(id int64) error {
@@ -106,39 +125,3 @@ func (s *Subscriptions) Delete(id int64) error {
 
 	return err
 }
-
-func (s *Subscriptions) PendingNotifications() ([]Notification, error) {
-	stmt := `SELECT
-			subscriptions.id,
-			subscriptions.server_id,
-			subscriptions.channel_id,
-			subscriptions.collection_name,
-			articles.id,
-			articles.title,
-			articles.link,
-			articles.pub_date
-		FROM subscriptions
-		INNER JOIN articles ON subscriptions.feed_id=articles.feed_id
-		WHERE articles.pub_date > subscriptions.last_pub_date
-		ORDER BY articles.pub_date ASC`
-
-	var notifications []Notification
-
-	rows, err := s.db.Query(stmt)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var n Notification
-		err := rows.Scan(&n.SubscriptionID, &n.ServerID, &n.ChannelID, &n.CollectionName, &n.ArticleID, &n.Title, &n.Link, &n.PubDate)
-		if err != nil {
-			return nil, err
-		}
-
-		notifications = append(notifications, n)
-	}
-
-	return notifications, nil
-}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultAnnouncementTemplate = `🪿 HONK! New item from collection {{printf "%q" .Collection}}`
+
+	// Discord rejects message content longer than this.
+	messageContentLimit = 2000
+	templateLengthLimit = 1000
+
+	// A template stops rendering once it has produced this much, so that
+	// it can't make goose use up its memory.
+	templateOutputLimit = 4 * messageContentLimit
+)
+
+var (
+	ErrTemplateTooLong       = errors.New("template is too long")
+	ErrTemplateEmpty         = errors.New("template renders to an empty message")
+	ErrTemplateOutputTooLong = errors.New("template renders to a message that is far too long")
+)
+
+// AnnouncementData is what a subscription's message template can refer to.
+type AnnouncementData struct {
+	Title       string
+	Link        string
+	Author      string
+	Description string
+	Collection  string
+	Feed        string
+	Published   time.Time
+	Media       string
+	Episode     string
+	Duration    string
+}
+
+func newAnnouncementData(n *Notification) AnnouncementData {
+	data := AnnouncementData{
+		Title:       n.Article.Title,
+		Link:        n.Article.Link,
+		Author:      n.Article.Author,
+		Description: n.Article.Description,
+		Collection:  n.CollectionName,
+		Feed:        n.FeedTitle,
+		Published:   n.Article.Published,
+		Media:       n.Article.MediaURL,
+		Episode:     episodeLabel(&n.Article),
+	}
+
+	if n.Article.Duration > 0 {
+		data.Duration = formatDuration(n.Article.Duration)
+	}
+
+	return data
+}
+
+// sampleAnnouncement is rendered when a template is saved to catch
+// references to fields that don't exist.
+var sampleAnnouncement = AnnouncementData{
+	Title:       "Goose spotted on the pond",
+	Link:        "https://example.com/geese/1",
+	Author:      "Mother Goose",
+	Description: "Honk honk honk.",
+	Collection:  "Pond News",
+	Feed:        "Pond News Daily",
+	Published:   time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+	Media:       "https://example.com/geese/1.mp3",
+	Episode:     "Episode 1",
+	Duration:    "42:00",
+}
+
+// parseAnnouncementTemplate parses text as a message template and renders
+// it once against sample data so that mistakes are reported when the
+// template is saved rather than when an item is announced.
+func parseAnnouncementTemplate(text string) (*template.Template, error) {
+	if len(text) > templateLengthLimit {
+		return nil, ErrTemplateTooLong
+	}
+
+	tmpl, err := newAnnouncementTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&limitedWriter{w: &sb, n: templateOutputLimit}, sampleAnnouncement); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(sb.String()) == "" {
+		return nil, ErrTemplateEmpty
+	}
+
+	return tmpl, nil
+}
+
+// renderAnnouncement produces the message content announcing n, using
+// the subscription's template if it has one.
+func renderAnnouncement(n *Notification) (string, error) {
+	text := n.Template
+	if text == "" {
+		text = defaultAnnouncementTemplate
+	}
+
+	tmpl, err := newAnnouncementTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+
+	// Long items can push a template that was fine with the sample past
+	// the limit. What was rendered by then is more than enough to fill
+	// a message.
+	var sb strings.Builder
+	err = tmpl.Execute(&limitedWriter{w: &sb, n: templateOutputLimit}, newAnnouncementData(n))
+	if err != nil && !errors.Is(err, ErrTemplateOutputTooLong) {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+
+	return truncate(sb.String(), messageContentLimit), nil
+}
+
+func newAnnouncementTemplate(text string) (*template.Template, error) {
+	return template.New("announcement").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"printf": limitedPrintf}).
+		Parse(text)
+}
+
+// limitedWriter writes at most n more bytes to w and fails after that.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		written, err := l.w.Write(p[:l.n])
+		l.n -= written
+		if err != nil {
+			return written, err
+		}
+		return written, ErrTemplateOutputTooLong
+	}
+
+	written, err := l.w.Write(p)
+	l.n -= written
+	return written, err
+}
+
+// limitedPrintf is the template's printf, except that it refuses widths
+// and precisions that would pad its result past templateOutputLimit all
+// by itself, since fmt builds the whole result before the template gets
+// to write it.
+func limitedPrintf(format string, args ...any) (string, error) {
+	fromArgs := false
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		// Flags, argument indexes, widths and precisions come between
+		// the % and the verb.
+		num := 0
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[i]) >= 0; i++ {
+			switch c := format[i]; {
+			case c >= '0' && c <= '9':
+				num = num*10 + int(c-'0')
+				if num > templateOutputLimit {
+					return "", ErrTemplateOutputTooLong
+				}
+			case c == '*':
+				fromArgs = true
+				num = 0
+			default:
+				num = 0
+			}
+		}
+	}
+
+	// Widths and precisions taken from the arguments could be any of
+	// the integers among them.
+	if fromArgs {
+		for _, arg := range args {
+			v := reflect.ValueOf(arg)
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				if v.Int() > templateOutputLimit || v.Int() < -templateOutputLimit {
+					return "", ErrTemplateOutputTooLong
+				}
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				if v.Uint() > templateOutputLimit {
+					return "", ErrTemplateOutputTooLong
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf(format, args...), nil
+}
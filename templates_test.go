@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAnnouncementTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{name: "fields", text: "{{.Collection}}: {{.Title}} by {{.Author}} {{.Link}}"},
+		{name: "published format", text: `{{.Title}} ({{.Published.Format "Jan 2"}})`},
+		{name: "plain text", text: "Something new!"},
+		{name: "unknown field", text: "{{.Nope}}", wantErr: true},
+		{name: "syntax error", text: "{{.Title", wantErr: true},
+		{name: "empty output", text: "{{if false}}x{{end}}   ", wantErr: true},
+		{name: "too long", text: strings.Repeat("honk", 300), wantErr: true},
+		{name: "printf", text: `{{printf "%-10s|%5.2f" .Title 4.2}}`},
+		{name: "huge padding", text: `{{printf "%2000000000s" ""}}`, wantErr: true},
+		{name: "padding from arguments", text: `{{printf "%*s" 100000 ""}}`, wantErr: true},
+		{name: "too long output", text: `{{printf "%5000s" .Title}}{{printf "%5000s" .Title}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAnnouncementTemplate(tt.text)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("want err=%v, got err=%v", tt.wantErr, err)
+			}
+		})
+	}
+
+	_, err := parseAnnouncementTemplate(strings.Repeat("honk", 300))
+	if !errors.Is(err, ErrTemplateTooLong) {
+		t.Errorf("want err=%v, got err=%v", ErrTemplateTooLong, err)
+	}
+
+	_, err = parseAnnouncementTemplate(`{{printf "%5000s" .Title}}{{printf "%5000s" .Title}}`)
+	if !errors.Is(err, ErrTemplateOutputTooLong) {
+		t.Errorf("want err=%v, got err=%v", ErrTemplateOutputTooLong, err)
+	}
+}
+
+func TestRenderAnnouncement(t *testing.T) {
+	n := &Notification{
+		CollectionName: "Go Blog",
+		FeedTitle:      "The Go Blog",
+		Article: Article{
+			Title:     "Go 1.21 is released!",
+			Link:      "https://go.dev/blog/go1.21",
+			Author:    "Eli Bendersky",
+			Published: time.Date(2023, 8, 8, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	got, err := renderAnnouncement(n)
+	if err != nil {
+		t.Fatalf("render default template: %v", err)
+	}
+	if want := `🪿 HONK! New item from collection "Go Blog"`; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	n.Template = `{{.Feed}}: {{.Title}} by {{.Author}} on {{.Published.Format "2006-01-02"}} {{.Link}}`
+	got, err = renderAnnouncement(n)
+	if err != nil {
+		t.Fatalf("render custom template: %v", err)
+	}
+	if want := "The Go Blog: Go 1.21 is released! by Eli Bendersky on 2023-08-08 https://go.dev/blog/go1.21"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	n.Template = `{{.Description}}`
+	n.Article.Description = strings.Repeat("honk ", 600)
+	got, err = renderAnnouncement(n)
+	if err != nil {
+		t.Fatalf("render long template: %v", err)
+	}
+	if len([]rune(got)) != messageContentLimit {
+		t.Errorf("want rendered message truncated to %d runes, got %d", messageContentLimit, len([]rune(got)))
+	}
+
+	n.Article.Description = strings.Repeat("honk ", 10000)
+	got, err = renderAnnouncement(n)
+	if err != nil {
+		t.Fatalf("render very long template: %v", err)
+	}
+	if len([]rune(got)) != messageContentLimit {
+		t.Errorf("want rendered message truncated to %d runes, got %d", messageContentLimit, len([]rune(got)))
+	}
+}